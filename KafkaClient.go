@@ -4,58 +4,135 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"time"
 
 	"github.com/Shopify/sarama"
 )
 
-// Generate tls configuration from certificates files
-func getTLSConfig(clientcertfile, clientkeyfile []byte, cacertfile string) (*tls.Config, error) {
-	// Load client cert
-	clientcert, err := tls.X509KeyPair(clientcertfile, clientkeyfile)
-	if err != nil {
-		return nil, err
-	}
+const (
+	// defaultKafkaVersion is recent enough for record headers, which arrived with Kafka 0.11
+	defaultKafkaVersion = "1.0.0"
 
-	// Load CA cert pool
-	cacert, err := ioutil.ReadFile(cacertfile)
-	if err != nil {
-		return nil, err
+	defaultProduceRetries    = 5
+	defaultRetryBackoff      = 250 * time.Millisecond
+	defaultRetryBackoffLimit = 10 * time.Second
+)
+
+// produceRetry configures how often, and after how long, the producer retries retriable errors.
+// The backoff doubles after every retry of a message, up to the limit.
+type produceRetry struct {
+	max          int
+	backoff      time.Duration
+	backoffLimit time.Duration
+}
+
+// backoffFunc returns the delay before the retries-th retry of a message
+func (r produceRetry) backoffFunc(retries int, maxRetries int) time.Duration {
+	backoff := r.backoff
+	for i := 1; i < retries && backoff < r.backoffLimit; i++ {
+		backoff *= 2
 	}
-	cacertpool := x509.NewCertPool()
-	cacertpool.AppendCertsFromPEM(cacert)
+	if backoff > r.backoffLimit {
+		backoff = r.backoffLimit
+	}
+	return backoff
+}
 
+// Generate tls configuration from the credentials. Without a CA certificate, the brokers are verified
+// with the system roots, and without a client certificate the connections are server-only.
+func getTLSConfig(credentials kafkaTLSCredentials) (*tls.Config, error) {
 	// Generate tls config
 	tlsConfig := tls.Config{}
-	tlsConfig.RootCAs = cacertpool
-	tlsConfig.Certificates = []tls.Certificate{clientcert}
-	tlsConfig.BuildNameToCertificate()
+	if credentials.caCert != nil {
+		cacertpool := x509.NewCertPool()
+		if !cacertpool.AppendCertsFromPEM(credentials.caCert) {
+			return nil, fmt.Errorf("invalid CA certificate, it has no PEM certificate")
+		}
+		tlsConfig.RootCAs = cacertpool
+	}
+
+	// Load client cert
+	if credentials.clientCert != nil {
+		clientcert, err := tls.X509KeyPair(credentials.clientCert, credentials.clientKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{clientcert}
+		tlsConfig.BuildNameToCertificate()
+	}
 	return &tlsConfig, nil
 }
 
-// getKafkaProducer creates new basic Kafka-producer.
-func getKafkaProducer(brokers []string, tlsEnabled bool, tlsClientCert, tlsClientKey []byte, tlsCACert string) (sarama.AsyncProducer, error) {
+// getKafkaConfig creates the basic Kafka-producer configuration.
+// With preservePartitions, every message is produced to the partition it carries.
+// Record headers need at least Kafka 0.11, and record timestamps at least Kafka 0.10.
+// Retriable errors, such as a leader election, are retried by the producer. Other errors, such as
+// MessageSizeTooLarge or authorization errors, fail at once.
+func getKafkaConfig(tlsEnabled bool, tlsCredentials kafkaTLSCredentials, sasl saslOptions, preservePartitions bool, kafkaVersion string, retry produceRetry) (*sarama.Config, error) {
 	// Create kafka producer config
 	config := sarama.NewConfig()
+	version, err := sarama.ParseKafkaVersion(kafkaVersion)
+	if err != nil {
+		return nil, err
+	}
+	config.Version = version
 	config.Producer.Return.Successes = true
 	config.Producer.Return.Errors = true
+	config.Producer.Retry.Max = retry.max
+	if retry.backoff > 0 {
+		config.Producer.Retry.BackoffFunc = retry.backoffFunc
+	}
+	// A retried batch would land after the batches sent behind it, so one request at a time keeps the
+	// records of a partition in their backup order
+	config.Net.MaxOpenRequests = 1
+	if preservePartitions {
+		config.Producer.Partitioner = sarama.NewManualPartitioner
+	}
 
 	// Configure tls if it's required
 	if tlsEnabled {
-		tlsConfig, err := getTLSConfig(tlsClientCert, tlsClientKey, tlsCACert)
+		tlsConfig, err := getTLSConfig(tlsCredentials)
 		if err != nil {
-			WriteLog(logfileAdmin, logLevelError, componentKafka, err.Error())
-			log.Fatal(err)
+			logger.error(componentKafka, err.Error())
+			return nil, err
 		}
 
 		config.Net.TLS.Enable = true
 		config.Net.TLS.Config = tlsConfig
 	}
+
+	// SASL authenticates over TLS, or over plaintext connections such as the ones of a local broker
+	if err := sasl.configure(config); err != nil {
+		logger.error(componentKafka, err.Error())
+		return nil, err
+	}
+	return config, nil
+}
+
+// getKafkaProducer creates new basic Kafka-producer.
+func getKafkaProducer(brokers []string, config *sarama.Config) (sarama.AsyncProducer, error) {
 	return sarama.NewAsyncProducer(brokers, config)
 }
 
+// checkTopicPartitions fails when the topic has fewer partitions than required
+func checkTopicPartitions(brokers []string, config *sarama.Config, topic string, required int32) error {
+	client, err := sarama.NewClient(brokers, config)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	partitions, err := client.Partitions(topic)
+	if err != nil {
+		return fmt.Errorf("error reading the partitions of topic %s: %v", topic, err)
+	}
+	if int32(len(partitions)) < required {
+		return fmt.Errorf("topic %s has %d partitions, but the restored objects need %d to preserve partitions", topic, len(partitions), required)
+	}
+	return nil
+}
+
 // createKafkaTopic is used for tests.
 func createKafkaTopic(kafkaBrokerHost string, topic string) {
 	// Set broker configuration
@@ -100,7 +177,7 @@ func createKafkaTopic(kafkaBrokerHost string, topic string) {
 	for key, val := range t {
 		if val.ErrMsg != nil {
 			log.Println("There is an error: ", val.ErrMsg)
-			WriteLog(logfileAdmin, logLevelPanic, componentKafka, val.ErrMsg)
+			logger.fatal(componentKafka, val.ErrMsg)
 		} else {
 			log.Printf("Topic '%s' created successfully ", key)
 		}
@@ -111,22 +188,68 @@ func createKafkaTopic(kafkaBrokerHost string, topic string) {
 	broker.Close()
 }
 
-// ProcessResponse grabs results and errors from kafka async producer
-func ProcessResponse(kafkaProducer sarama.AsyncProducer) {
-	for {
+// ProcessResponse grabs results and errors from kafka async producer, until the producer is closed.
+// Every result is counted in the delivery stats, and acknowledged records are recorded in the checkpoint.
+// Records that failed are written into the dead-letter sink when there's one, and are then recorded in the
+// checkpoint too once the sink stored them, since they can be replayed from there.
+func ProcessResponse(kafkaProducer sarama.AsyncProducer, checkpoint *checkpointTracker, stats *deliveryStats, deadLetters deadLetterSink) {
+	successes, errors := kafkaProducer.Successes(), kafkaProducer.Errors()
+	for successes != nil || errors != nil {
 		select {
 		// Produce was done successfully
-		case result := <-kafkaProducer.Successes():
-			fmt.Println("result:", result)
+		case result, ok := <-successes:
+			if !ok {
+				successes = nil
+				continue
+			}
+			stats.acked(result.Topic, result.Partition)
+			if record, ok := result.Metadata.(*restoreRecord); ok {
+				observeProduced(result.Topic, record)
+				checkpoint.acked(record)
+			}
 		// Produce was failed
-		case err := <-kafkaProducer.Errors():
-			fmt.Println("err:", err)
-			fmt.Println("this is only a test")
-			if err != nil {
-				WriteLog(logfileAdmin, logLevelPanic, componentKafka, err.Error())
-			} else {
-				WriteLog(logfileAdmin, logLevelWarning, componentKafka, fmt.Sprintf("the error is %v", err))
+		case err, ok := <-errors:
+			if !ok {
+				errors = nil
+				continue
+			}
+			if err == nil || err.Msg == nil {
+				logger.warning(componentKafka, fmt.Sprintf("the error is %v", err))
+				continue
+			}
+			stats.failed(err.Msg.Topic, err.Msg.Partition)
+			record, ok := err.Msg.Metadata.(*restoreRecord)
+			if !ok {
+				logger.error(componentKafka, err.Error())
+				continue
 			}
+			observeProduced(err.Msg.Topic, record)
+			logger.error(componentKafka, fmt.Sprintf("Failed to produce %s line %d into %s: %v", record.ObjectKey, record.Line, err.Msg.Topic, err.Err))
+			if deadLetters == nil {
+				continue
+			}
+			stored, dlqErr := deadLetters.write(newDeadLetter(record, err.Msg.Topic, err.Err))
+			if dlqErr != nil {
+				logger.error(componentKafka, fmt.Sprintf("Failed to write %s line %d into the dead-letter sink %s: %v", record.ObjectKey, record.Line, deadLetters, dlqErr))
+			}
+			deadLettersStored(stored, checkpoint, stats)
 		}
 	}
+
+	// The dead letters still held by the sink are only acknowledged once they're stored
+	if deadLetters != nil {
+		stored, err := deadLetters.flush()
+		if err != nil {
+			logger.error(componentKafka, fmt.Sprintf("Failed to flush the dead-letter sink %s: %v", deadLetters, err))
+		}
+		deadLettersStored(stored, checkpoint, stats)
+	}
+}
+
+// deadLettersStored acknowledges the records of the dead letters that are stored for good
+func deadLettersStored(stored []deadLetter, checkpoint *checkpointTracker, stats *deliveryStats) {
+	for _, entry := range stored {
+		stats.deadLettered(entry.TargetTopic)
+		checkpoint.acked(entry.record)
+	}
 }
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestProduceRetryBackoff(t *testing.T) {
+	tests := []struct {
+		retry   produceRetry
+		retries int
+		backoff time.Duration
+	}{
+		{retry: produceRetry{backoff: 250 * time.Millisecond, backoffLimit: 10 * time.Second}, retries: 1, backoff: 250 * time.Millisecond},
+		{retry: produceRetry{backoff: 250 * time.Millisecond, backoffLimit: 10 * time.Second}, retries: 2, backoff: 500 * time.Millisecond},
+		{retry: produceRetry{backoff: 250 * time.Millisecond, backoffLimit: 10 * time.Second}, retries: 4, backoff: 2 * time.Second},
+		{retry: produceRetry{backoff: 250 * time.Millisecond, backoffLimit: 10 * time.Second}, retries: 6, backoff: 8 * time.Second},
+		{retry: produceRetry{backoff: 250 * time.Millisecond, backoffLimit: 10 * time.Second}, retries: 7, backoff: 10 * time.Second},
+		{retry: produceRetry{backoff: 250 * time.Millisecond, backoffLimit: 10 * time.Second}, retries: 1000, backoff: 10 * time.Second},
+		{retry: produceRetry{backoff: time.Second, backoffLimit: 100 * time.Millisecond}, retries: 1, backoff: 100 * time.Millisecond},
+	}
+
+	for _, test := range tests {
+		if backoff := test.retry.backoffFunc(test.retries, 5); backoff != test.backoff {
+			t.Errorf("backoff of retry %d with %+v is %v, want %v", test.retries, test.retry, backoff, test.backoff)
+		}
+	}
+}
+
+func TestGetKafkaConfigRetry(t *testing.T) {
+	retry := produceRetry{max: 3, backoff: 100 * time.Millisecond, backoffLimit: time.Second}
+	config, err := getKafkaConfig(false, kafkaTLSCredentials{}, saslOptions{}, false, defaultKafkaVersion, retry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Producer.Retry.Max != 3 || config.Producer.Retry.BackoffFunc == nil || config.Producer.Retry.BackoffFunc(3, 3) != 400*time.Millisecond {
+		t.Errorf("producer retries %d times with backoff function %v", config.Producer.Retry.Max, config.Producer.Retry.BackoffFunc != nil)
+	}
+	if err := config.Validate(); err != nil {
+		t.Errorf("invalid producer configuration: %v", err)
+	}
+}
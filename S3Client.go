@@ -1,8 +1,6 @@
 package main
 
 import (
-	"sync"
-
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
@@ -10,86 +8,507 @@ import (
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
+	"regexp"
+	"sort"
+	"strings"
 	"time"
 )
 
-// function that returns a list of objects in a certin date
-func listObjectsForDate(s3Session *s3.S3, bucket string, topic string, date string) ([]*s3.Object, error) {
-	fmt.Println("Listing objects")
-	input := &s3.ListObjectsInput{
+// listObjectsForDate returns every object under the prefix of a certain date, sorted by key.
+// ListObjectsV2 returns at most 1000 keys per call, so all the pages are walked.
+// When matcher isn't nil, only the keys it matches are returned.
+func listObjectsForDate(s3Session *s3.S3, bucket string, prefix string, matcher *regexp.Regexp) ([]*s3.Object, error) {
+	input := &s3.ListObjectsV2Input{
 		Bucket: aws.String(bucket),
-		Prefix: aws.String(fmt.Sprintf("topics/%s/%s", topic, date)),
+		Prefix: aws.String(prefix),
 	}
-	fmt.Println("AFTER LIST S3")
-	result, err := s3Session.ListObjects(input)
+
+	var objects []*s3.Object
+	err := s3Session.ListObjectsV2Pages(input, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, object := range page.Contents {
+			if matcher == nil || matcher.MatchString(aws.StringValue(object.Key)) {
+				objects = append(objects, object)
+			}
+		}
+		return true
+	})
 
 	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			case s3.ErrCodeNoSuchBucket:
-				fmt.Println(s3.ErrCodeNoSuchBucket, aerr.Error())
-			default:
-				fmt.Println(aerr.Error())
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchBucket {
+			logger.error(componentS3, fmt.Sprintf("Bucket %s doesn't exist", bucket))
+		}
+		return nil, err
+	}
+
+	// Kafka Connect pads the start offset in the object name, so sorting by key keeps the offset order
+	sort.Slice(objects, func(i, j int) bool {
+		return aws.StringValue(objects[i].Key) < aws.StringValue(objects[j].Key)
+	})
+	logger.info(componentS3, fmt.Sprintf("Listed %d objects for %s", len(objects), *input.Prefix))
+
+	return objects, nil
+}
+
+// listTopicNames returns the names of the topic directories right under the prefix
+func listTopicNames(s3Client *s3.S3, bucket string, prefix string) ([]string, error) {
+	input := &s3.ListObjectsV2Input{
+		Bucket:    aws.String(bucket),
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String("/"),
+	}
+
+	var topics []string
+	err := s3Client.ListObjectsV2Pages(input, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, commonPrefix := range page.CommonPrefixes {
+			topic := strings.TrimSuffix(strings.TrimPrefix(aws.StringValue(commonPrefix.Prefix), prefix), "/")
+			if topic != "" {
+				topics = append(topics, topic)
 			}
-		} else {
-			WriteLog(logfileAdmin, logLevelPanic, componentS3, err.Error())
-			fmt.Println(err.Error())
 		}
+		return true
+	})
+	if err != nil {
+		logger.error(componentS3, err.Error())
 		return nil, err
 	}
+	logger.info(componentS3, fmt.Sprintf("Found %d topics under %s", len(topics), prefix))
+	return topics, nil
+}
 
-	return result.Contents, nil
+// restoreObject is a backup object selected for the restore
+type restoreObject struct {
+	Key    string
+	Topic  string
+	Size   int64
+	Period time.Time
+	// Target is the topic the records are restored into
+	Target string
+	// KeysKey and HeadersKey are the side objects with the keys and headers of the records, when there are any
+	KeysKey    string
+	HeadersKey string
+	// Partition and StartOffset are parsed from the Kafka Connect object name, and are -1 when it can't be parsed
+	Partition   int32
+	StartOffset int64
+
+	// filter is set for objects of periods that are only partly inside the restore window
+	filter bool
+}
+
+// objectStream carries the records of one object from its download worker to the producer
+type objectStream struct {
+	object  restoreObject
+	records chan *restoreRecord
+	err     error
 }
 
-// Download all objects between a given start date and end date
+// listDateRange lists the objects of every layout period between the start and end of the window, in restore order.
+// Objects the checkpoint completed before a restart are left out.
+func listDateRange(s3Client *s3.S3, bucket string, topic string, layout *keyLayout, window restoreWindow, checkpoint *checkpointTracker) ([]restoreObject, error) {
+	var objects []restoreObject
+	for _, period := range layout.periods(window.start, window.end) {
+		objectList, err := listObjectsForDate(s3Client, bucket, layout.prefix(topic, period), layout.matcher(topic, period))
+		if err != nil {
+			return nil, err
+		}
+		logger.info(componentS3, fmt.Sprintf("There are: %d files in period %v", len(objectList), period))
+
+		filter := !window.covers(period, layout.next(period))
+		sideObjects := make(map[string]bool)
+		for _, element := range objectList {
+			if isSideObject(aws.StringValue(element.Key)) {
+				sideObjects[aws.StringValue(element.Key)] = true
+			}
+		}
+
+		for _, element := range objectList {
+			if isSideObject(aws.StringValue(element.Key)) || checkpoint.isCompleted(aws.StringValue(element.Key)) {
+				continue
+			}
+			object := restoreObject{
+				Key:         aws.StringValue(element.Key),
+				Topic:       topic,
+				Size:        aws.Int64Value(element.Size),
+				Period:      period,
+				Partition:   -1,
+				StartOffset: -1,
+				filter:      filter,
+			}
+			if _, partition, startOffset, err := parseConnectObjectName(object.Key); err == nil {
+				object.Partition = partition
+				object.StartOffset = startOffset
+			}
+			if keysKey := sideObjectKey(object.Key, sideObjectKeys); sideObjects[keysKey] {
+				object.KeysKey = keysKey
+			}
+			if headersKey := sideObjectKey(object.Key, sideObjectHeaders); sideObjects[headersKey] {
+				object.HeadersKey = headersKey
+			}
+			objects = append(objects, object)
+		}
+	}
+	return objects, nil
+}
+
+// Download all objects listed for the date range by listDateRange, and stream their records.
+// The records channel is closed once every object was read, once an object fails, or once ctx is cancelled.
+// The error is the one of the object that failed, or the one of ctx.
 // move to main.go
-func downloadDateRange(s3Session *session.Session, bucket string, topic string, start time.Time, end time.Time, mainChan chan []byte, filesCountChan chan int, wg *sync.WaitGroup) {
-	WriteLog(logfileAdmin, logLevelInfo, componentS3, fmt.Sprintf("Start downloadDateRange from %v to %v", start, end))
-	s3Downloader := s3manager.NewDownloader(s3Session)
-	for end.After(start) || end.Equal(start) {
-		WriteLog(logfileAdmin, logLevelInfo, componentS3, fmt.Sprintf("Downloadging files for day %v", start))
-		objectList, err := listObjectsForDate(s3.New(s3Session), bucket, topic, string(start.Format("year=2006/month=01/day=02")))
-		filesCountChan <- len(objectList)
+func downloadDateRange(ctx context.Context, s3Client *s3.S3, bucket string, objectList []restoreObject, options streamOptions, records chan<- *restoreRecord) error {
+	logger.info(componentS3, fmt.Sprintf("Start downloadDateRange of %d objects", len(objectList)))
+	defer close(records)
+
+	if err := downloadObjectList(ctx, s3Client, bucket, objectList, options, records); err != nil {
+		logger.warning(componentS3, fmt.Sprintf("Download from S3 stopped before the last object: %v", err))
+		return err
+	}
+
+	logger.info(componentS3, fmt.Sprintf("Finish to download files from S3"))
+	return nil
+}
+
+// downloadObjectList streams up to options.workers objects at once, and forwards their records into the
+// records channel in the order of the list, so the per-partition offset order is kept.
+// A worker slot is only freed once all the records of its object were forwarded, which bounds the memory
+// to options.workers objects waiting with up to options.recordsQueueSize records each.
+// Cancelling ctx returns the error of ctx without forwarding the records still queued, and an object that fails
+// stops the forwarding with its error. Either way the workers are stopped before it returns.
+func downloadObjectList(ctx context.Context, s3Client *s3.S3, bucket string, objectsToDownload []restoreObject, options streamOptions, records chan<- *restoreRecord) error {
+	logger.info(componentS3, fmt.Sprintf("Start downloadObjectList of %d objects with %d workers", len(objectsToDownload), options.workers))
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	slots := make(chan struct{}, options.workers)
+	streams := make(chan *objectStream, options.workers)
+
+	// Start a worker for every object, in order, as long as a slot is free
+	go func() {
+		defer close(streams)
+		for _, element := range objectsToDownload {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			stream := &objectStream{object: element, records: make(chan *restoreRecord, options.recordsQueueSize)}
+			streams <- stream
+
+			go func() {
+				defer close(stream.records)
+				stream.err = streamObject(ctx, s3Client, bucket, stream.object, options, stream.records)
+			}()
+		}
+	}()
+
+	// Forward the records of the objects in the order they were started
+	for stream := range streams {
+		logger.info(componentS3, fmt.Sprintf("Now processing file %s", stream.object.Key))
+		observeObjectStarted(stream.object)
+		for record := range stream.records {
+			options.checkpoint.sent(record)
+			select {
+			case records <- record:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		<-slots
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		observeObjectFinished(stream.object, stream.err)
+		if stream.err == nil {
+			options.checkpoint.objectRead(stream.object.Key)
+		}
+
+		if stream.err != nil {
+			logger.error(componentS3, stream.err.Error())
+			return stream.err
+		}
+	}
+	return ctx.Err()
+}
+
+// objectBody is the GetObject body of an S3 object, decompressed on the way
+type objectBody struct {
+	key           string
+	body          io.ReadCloser
+	decompressed  io.ReadCloser
+	counter       *countingReader
+	contentLength *int64
+}
+
+// openObjectBody starts reading an object, until ctx is cancelled
+func openObjectBody(ctx context.Context, s3Client *s3.S3, bucket string, key string, options streamOptions) (*objectBody, error) {
+	output, err := s3Client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The compressed bytes are counted, to be checked against the ContentLength
+	counter := &countingReader{reader: output.Body}
+	decompressed, err := newDecompressingReader(counter, key, options.compression)
+	if err != nil {
+		output.Body.Close()
+		return nil, fmt.Errorf("error decompressing %s: %v", key, err)
+	}
+
+	return &objectBody{
+		key:           key,
+		body:          output.Body,
+		decompressed:  decompressed,
+		counter:       counter,
+		contentLength: output.ContentLength,
+	}, nil
+}
+
+// verify checks that the bytes read match the ContentLength of the object
+func (o *objectBody) verify() error {
+	if o.contentLength != nil && o.counter.bytes != *o.contentLength {
+		return fmt.Errorf("object %s is incomplete: read %d bytes out of %d", o.key, o.counter.bytes, *o.contentLength)
+	}
+	return nil
+}
+
+func (o *objectBody) Close() error {
+	o.decompressed.Close()
+	return o.body.Close()
+}
+
+// objectLines reads the lines of an S3 object
+type objectLines struct {
+	*objectBody
+	reader *lineReader
+}
+
+// openObjectLines starts reading an object. Only one read buffer is held for it, whatever its size.
+func openObjectLines(ctx context.Context, s3Client *s3.S3, bucket string, key string, options streamOptions) (*objectLines, error) {
+	body, err := openObjectBody(ctx, s3Client, bucket, key, options)
+	if err != nil {
+		return nil, err
+	}
+	return &objectLines{
+		objectBody: body,
+		reader:     newLineReader(body.decompressed, options.bufferBytes, options.maxRecordBytes),
+	}, nil
+}
+
+// next returns the next line of the object, and io.EOF at its end. Blank lines are skipped unless the
+// reader keeps them.
+func (o *objectLines) next() ([]byte, error) {
+	line, err := o.reader.next()
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("error reading %s after line %d: %v", o.key, o.reader.line, err)
+	}
+	return line, err
+}
+
+// openSideLines opens the side object of an object, or returns nil when there's none
+func openSideLines(ctx context.Context, s3Client *s3.S3, bucket string, key string, options streamOptions) (*objectLines, error) {
+	if key == "" {
+		return nil, nil
+	}
+	return openObjectLines(ctx, s3Client, bucket, key, options)
+}
+
+// nextSideLine returns the line of a side object that matches the current value line
+func nextSideLine(side *objectLines) ([]byte, error) {
+	if side == nil {
+		return nil, nil
+	}
+	line, err := side.next()
+	if err == io.EOF {
+		return nil, fmt.Errorf("side object %s has fewer records than its values", side.key)
+	}
+	return line, err
+}
+
+// verifySideLines checks that a side object ended together with its values, and was read completely
+func verifySideLines(side *objectLines) error {
+	if side == nil {
+		return nil
+	}
+	if _, err := side.next(); err != io.EOF {
 		if err != nil {
-			WriteLog(logfileAdmin, logLevelPanic, componentS3, err.Error())
-			fmt.Println(err.Error())
-			panic(err)
-		} else {
-			fmt.Println("OK")
+			return err
 		}
+		return fmt.Errorf("side object %s has more records than its values", side.key)
+	}
+	return side.verify()
+}
 
-		downloadObjectList(s3Downloader, bucket, objectList, mainChan)
+// errObjectIdle stops the read of an object whose next record waited too long for room in its queue
+var errObjectIdle = errors.New("object read was idle for too long")
 
-		WriteLog(logfileAdmin, logLevelInfo, componentS3, fmt.Sprintf("Finish to download files for day %v", start))
-		start = start.AddDate(0, 0, 1)
+// recordSender sends the records of an object into its queue. A record that can't be queued within idleLimit
+// stops the read with errObjectIdle, so the body of the object doesn't sit idle until S3 drops the connection.
+// The records of the next read are only sent after the last one queued, which is found by its line.
+type recordSender struct {
+	records   chan<- *restoreRecord
+	idleLimit time.Duration
+	// queuedLine is the line of the last record queued
+	queuedLine int64
+	// idle is the record the read stopped on
+	idle *restoreRecord
+}
+
+// send queues a record that wasn't queued by an earlier read
+func (s *recordSender) send(ctx context.Context, record *restoreRecord) error {
+	if record.Line <= s.queuedLine {
+		return nil
+	}
+	var idle <-chan time.Time
+	if s.idleLimit > 0 {
+		timer := time.NewTimer(s.idleLimit)
+		defer timer.Stop()
+		idle = timer.C
 	}
+	select {
+	case s.records <- record:
+		s.queuedLine = record.Line
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-idle:
+		s.idle = record
+		return errObjectIdle
+	}
+}
 
-	WriteLog(logfileAdmin, logLevelInfo, componentS3, fmt.Sprintf("Finish to download files from S3"))
-	wg.Done()
+// streamObject reads the records of an object into the records channel, according to the format of the object
+// It stops with the error of ctx once ctx is cancelled.
+// An object whose records wait longer than options.idleLimit for room in the channel is closed, and read again
+// from its start once there's room, skipping the records already sent.
+func streamObject(ctx context.Context, s3Client *s3.S3, bucket string, object restoreObject, options streamOptions, records chan<- *restoreRecord) error {
+	sender := &recordSender{records: records, idleLimit: options.idleLimit}
+	for {
+		err := readObject(ctx, s3Client, bucket, object, options, sender)
+		if err != errObjectIdle {
+			return err
+		}
+		logger.debug(componentS3, fmt.Sprintf("Closed %s while its records wait for room, it's read again after line %d", object.Key, sender.queuedLine))
+		select {
+		case records <- sender.idle:
+			sender.queuedLine = sender.idle.Line
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
 }
 
-// returns a buffer
-// TODO check if buffer empties for each day
-func downloadObjectList(s3Downloader *s3manager.Downloader, bucket string, objectsToDownload []*s3.Object, mainChan chan []byte) {
-	WriteLog(logfileAdmin, logLevelInfo, componentS3, fmt.Sprintf("Start downloadObjectList"))
-	buffer := aws.NewWriteAtBuffer([]byte{})
-	for _, element := range objectsToDownload {
-		_, err := s3Downloader.Download(buffer, &s3.GetObjectInput{
-			Bucket: aws.String(bucket),
-			Key:    aws.String(*element.Key),
-		})
+// readObject reads the records of an object with sender, according to the format of the object
+func readObject(ctx context.Context, s3Client *s3.S3, bucket string, object restoreObject, options streamOptions, sender *recordSender) error {
+	switch detectObjectFormat(object.Key, options.objectFormat) {
+	case objectFormatAvro:
+		return streamAvroObject(ctx, s3Client, bucket, object, options, sender)
+	case objectFormatParquet:
+		return streamParquetObject(ctx, s3Client, bucket, object, options, sender)
+	}
+	return streamObjectLines(ctx, s3Client, bucket, object, options, sender)
+}
+
+// newRestoreRecord returns the record at index in an object. Its offset is only known when the object name
+// holds the start offset.
+func newRestoreRecord(object restoreObject, index int64, line int64) *restoreRecord {
+	record := &restoreRecord{ObjectKey: object.Key, Line: line}
+	record.Topic = object.Topic
+	record.Partition = object.Partition
+	record.Offset = -1
+	if object.StartOffset >= 0 {
+		record.Offset = object.StartOffset + index
+	}
+	return record
+}
+
+// streamObjectLines reads an object line by line, together with its keys and headers side objects, and sends
+// every record with the sender. Every record owns a copy of its bytes, and the object is only
+// complete once the bytes read match its ContentLength.
+// The records are sent as they're read, so when the body turns out to be short, the records before the cut
+// were already produced. They're checkpointed by their line, and the object isn't marked read, so a resume
+// carries on after them.
+// Records outside the window and records acknowledged before a restart are dropped.
+func streamObjectLines(ctx context.Context, s3Client *s3.S3, bucket string, object restoreObject, options streamOptions, sender *recordSender) error {
+	values, err := openObjectLines(ctx, s3Client, bucket, object.Key, options)
+	if err != nil {
+		return err
+	}
+	defer values.Close()
+
+	keys, err := openSideLines(ctx, s3Client, bucket, object.KeysKey, options)
+	if err != nil {
+		return err
+	}
+	if keys != nil {
+		defer keys.Close()
+	}
+	headers, err := openSideLines(ctx, s3Client, bucket, object.HeadersKey, options)
+	if err != nil {
+		return err
+	}
+	if headers != nil {
+		defer headers.Close()
+	}
+	// The lines of the side objects match the values one by one, so a blank line is a record of its own
+	if keys != nil || headers != nil {
+		for _, lines := range []*objectLines{values, keys, headers} {
+			if lines != nil {
+				lines.reader.keepBlank = true
+			}
+		}
+	}
+
+	recordsCount := 0
+	for index := int64(0); ; index++ {
+		value, err := values.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		keyLine, err := nextSideLine(keys)
 		if err != nil {
-			WriteLog(logfileAdmin, logLevelPanic, componentS3, err.Error())
-			panic(err)
-		} else {
+			return err
+		}
+		headersLine, err := nextSideLine(headers)
+		if err != nil {
+			return err
+		}
 
-			WriteLog(logfileAdmin, logLevelInfo, componentS3, fmt.Sprintf("Write buffer to chanel"))
-			mainChan <- buffer.Bytes()
+		record := newRestoreRecord(object, index, values.reader.line)
+		if err := options.decoder.decode(value, keyLine, headersLine, record); err != nil {
+			return fmt.Errorf("error decoding %s line %d: %v", object.Key, record.Line, err)
+		}
+
+		if object.filter && !recordInWindow(record, options.window, options.timestamps) {
+			continue
+		}
+		if options.checkpoint.skip(record) {
+			continue
+		}
+		if err := sender.send(ctx, record); err != nil {
+			return err
+		}
+		recordsCount++
+	}
+
+	for _, lines := range []*objectLines{keys, headers} {
+		if err := verifySideLines(lines); err != nil {
+			return err
 		}
 	}
+	if err := values.verify(); err != nil {
+		return err
+	}
+	logger.info(componentS3, fmt.Sprintf("Read %d records to restore (%d lines, %d bytes) from %s", recordsCount, values.reader.line, values.counter.bytes, object.Key))
+	return nil
 }
 
 func exitErrorf(msg string, args ...interface{}) {
@@ -104,8 +523,8 @@ func AddFileToS3(s *session.Session, cfg *aws.Config, localFilePath string, s3Bu
 
 	f, err := os.Open(localFilePath)
 	if err != nil {
-		WriteLog(logfileAdmin, logLevelPanic, componentS3, err.Error())
-		fmt.Println("Error while opening local file !", err)
+		logger.fatal(componentS3, err.Error())
+		return err
 	}
 
 	defer f.Close()
@@ -118,7 +537,7 @@ func AddFileToS3(s *session.Session, cfg *aws.Config, localFilePath string, s3Bu
 
 	key := fmt.Sprintf("/%s/%s/%s", topic, string(time.Format("Year=2006/Month=01/Day=02")), f.Name())
 
-	pufFileOutput, err := s3.New(s, cfg).PutObject(&s3.PutObjectInput{
+	putOutput, err := s3.New(s, cfg).PutObject(&s3.PutObjectInput{
 		Bucket:             aws.String("connect"),
 		Key:                aws.String(key),
 		Body:               bytes.NewReader(buffer),
@@ -128,11 +547,11 @@ func AddFileToS3(s *session.Session, cfg *aws.Config, localFilePath string, s3Bu
 	})
 
 	if err != nil {
-		WriteLog(logfileAdmin, logLevelPanic, componentS3, err.Error())
+		logger.fatal(componentS3, err.Error())
 		panic(err)
 	}
 
-	fmt.Printf("\n\n--pufFileOutput: %v\n--ERR: %v\n", pufFileOutput, err)
+	logger.debug(componentS3, fmt.Sprintf("Uploaded %s with ETag %s", key, aws.StringValue(putOutput.ETag)))
 
 	return err
 }
@@ -144,7 +563,7 @@ func GetClientCerdentials(s3Session *session.Session, projectName string, projec
 	path := fmt.Sprintf("kafka/%s/%s/client", projectDepType,projectSite)
 
 	buffer := aws.NewWriteAtBuffer([]byte{})
-	WriteLog(logfileAdmin, logLevelInfo, componentS3, fmt.Sprintf("retriveing client certificate"))
+	logger.info(componentS3, fmt.Sprintf("retriveing client certificate"))
 	_, ClientError := s3Downloader.Download(buffer, &s3.GetObjectInput{
 		Bucket: aws.String(projectName),
 		Key:    aws.String(fmt.Sprintf("%s/%s-kafka-%s-%s-client.%s.pem", path,projectName,projectDepType,projectSite,dnsSuffix)),
@@ -155,17 +574,17 @@ func GetClientCerdentials(s3Session *session.Session, projectName string, projec
 	ClientCertString := buffer.Bytes()
 
 	buffer = aws.NewWriteAtBuffer([]byte{})
-	WriteLog(logfileAdmin, logLevelInfo, componentS3, fmt.Sprintf("retriveing client key"))
+	logger.info(componentS3, fmt.Sprintf("retriveing client key"))
 	_, KeyError := s3Downloader.Download(buffer, &s3.GetObjectInput{
 		Bucket: aws.String(projectName),
 		Key:    aws.String(fmt.Sprintf("%s/%s-kafka-%s-%s-client.%s.key", path,projectName,projectDepType,projectSite,dnsSuffix)),
 	})
-	if ClientError != nil {
+	if KeyError != nil {
 		return nil, nil, KeyError
 	}
 	ClientKeyString := buffer.Bytes()
 
-	WriteLog(logfileAdmin, logLevelInfo, componentS3, fmt.Sprintf("retrived credentials successfully"))
+	logger.info(componentS3, fmt.Sprintf("retrived credentials successfully"))
 	return ClientCertString, ClientKeyString, nil
 }
   
\ No newline at end of file
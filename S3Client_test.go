@@ -0,0 +1,497 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+const testBucket = "backups"
+
+// fakeS3Object is an object of the fake S3, served after delay, or failed with a NoSuchKey error.
+// The last short bytes of the body aren't sent, as if the connection was cut.
+type fakeS3Object struct {
+	body  string
+	delay time.Duration
+	fail  bool
+	short int
+}
+
+// fakeS3 serves the ListObjectsV2, GetObject and PutObject calls of a single bucket, with path-style addressing.
+// It lists pageSize keys per page and records the continuation token of every list, and keeps track of the
+// GetObject calls being served at once.
+// The PutObject calls fail while failPuts is set.
+type fakeS3 struct {
+	mutex    sync.Mutex
+	objects  map[string]*fakeS3Object
+	pageSize int
+	lists    int
+	tokens   []string
+	gets     int
+	open     int
+	maxOpen  int
+	puts     map[string]string
+	failPuts bool
+}
+
+func newFakeS3(objects map[string]*fakeS3Object) *fakeS3 {
+	return &fakeS3{objects: objects, pageSize: 1000, puts: make(map[string]string)}
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/"+testBucket)
+	if path == r.URL.Path {
+		writeFakeS3Error(w, http.StatusNotFound, s3.ErrCodeNoSuchBucket)
+		return
+	}
+	key := strings.TrimPrefix(path, "/")
+
+	switch {
+	case r.Method == http.MethodGet && key == "":
+		f.list(w, r)
+	case r.Method == http.MethodGet:
+		f.get(w, r, key)
+	case r.Method == http.MethodPut:
+		body, _ := ioutil.ReadAll(r.Body)
+		f.mutex.Lock()
+		defer f.mutex.Unlock()
+		if f.failPuts {
+			writeFakeS3Error(w, http.StatusInternalServerError, "InternalError")
+			return
+		}
+		f.puts[key] = string(body)
+	default:
+		writeFakeS3Error(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+type fakeS3ListResult struct {
+	XMLName               xml.Name `xml:"ListBucketResult"`
+	Name                  string
+	Prefix                string
+	KeyCount              int
+	IsTruncated           bool
+	NextContinuationToken string `xml:",omitempty"`
+	Contents              []fakeS3ListEntry
+	CommonPrefixes        []fakeS3CommonPrefix
+}
+
+type fakeS3ListEntry struct {
+	Key  string
+	Size int
+}
+
+type fakeS3CommonPrefix struct {
+	Prefix string
+}
+
+// list returns the keys under the prefix from the continuation token on, which is the index of the first key.
+// With a delimiter, the keys sharing a directory under the prefix are listed once as a common prefix.
+func (f *fakeS3) list(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.lists++
+
+	prefix := r.URL.Query().Get("prefix")
+	delimiter := r.URL.Query().Get("delimiter")
+	entries := make(map[string]bool)
+	for key := range f.objects {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		if index := strings.Index(key[len(prefix):], delimiter); delimiter != "" && index >= 0 {
+			entries[key[:len(prefix)+index+len(delimiter)]] = true
+		} else {
+			entries[key] = false
+		}
+	}
+	var keys []string
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	token := r.URL.Query().Get("continuation-token")
+	f.tokens = append(f.tokens, token)
+	start, _ := strconv.Atoi(token)
+	result := fakeS3ListResult{Name: testBucket, Prefix: prefix}
+	for _, key := range keys[start:] {
+		if result.KeyCount == f.pageSize {
+			result.IsTruncated = true
+			result.NextContinuationToken = strconv.Itoa(start + f.pageSize)
+			break
+		}
+		if entries[key] {
+			result.CommonPrefixes = append(result.CommonPrefixes, fakeS3CommonPrefix{Prefix: key})
+		} else {
+			result.Contents = append(result.Contents, fakeS3ListEntry{Key: key, Size: len(f.objects[key].body)})
+		}
+		result.KeyCount++
+	}
+	xml.NewEncoder(w).Encode(result)
+}
+
+func (f *fakeS3) get(w http.ResponseWriter, r *http.Request, key string) {
+	f.mutex.Lock()
+	f.gets++
+	f.open++
+	if f.open > f.maxOpen {
+		f.maxOpen = f.open
+	}
+	object := f.objects[key]
+	f.mutex.Unlock()
+	defer func() {
+		f.mutex.Lock()
+		f.open--
+		f.mutex.Unlock()
+	}()
+
+	if object == nil || object.fail {
+		writeFakeS3Error(w, http.StatusNotFound, s3.ErrCodeNoSuchKey)
+		return
+	}
+	select {
+	case <-time.After(object.delay):
+	case <-r.Context().Done():
+		return
+	}
+	// Only the open ranges of the Parquet reads are served
+	body := object.body
+	if start, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(r.Header.Get("Range"), "bytes="), "-")); err == nil && start <= len(body) {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, len(body)-1, len(body)))
+		body = body[start:]
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.WriteHeader(http.StatusPartialContent)
+	} else {
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	}
+	w.Write([]byte(body[:len(body)-object.short]))
+}
+
+func writeFakeS3Error(w http.ResponseWriter, status int, code string) {
+	w.WriteHeader(status)
+	w.Write([]byte("<Error><Code>" + code + "</Code><Message>" + code + "</Message></Error>"))
+}
+
+// newTestS3Client returns an S3 client of the fake S3 served by server
+func newTestS3Client(server *httptest.Server) *s3.S3 {
+	s3Session := session.Must(session.NewSession(&aws.Config{
+		Endpoint:         aws.String(server.URL),
+		Region:           aws.String("us-east-1"),
+		Credentials:      credentials.NewStaticCredentials("AKID", "SECRET", ""),
+		S3ForcePathStyle: aws.Bool(true),
+		DisableSSL:       aws.Bool(true),
+		MaxRetries:       aws.Int(0),
+	}))
+	return s3.New(s3Session)
+}
+
+// testStreamOptions returns the options to stream objects of lines as values
+func testStreamOptions(workers int, checkpoint *checkpointTracker) streamOptions {
+	decoder, _ := newRecordDecoder(recordFormatValue, envelopeFields{}, "")
+	return streamOptions{
+		bufferBytes:      defaultStreamBufferBytes,
+		maxRecordBytes:   defaultMaxRecordBytes,
+		recordsQueueSize: defaultRecordsQueueSize,
+		workers:          workers,
+		compression:      compressionNone,
+		objectFormat:     objectFormatLines,
+		decoder:          decoder,
+		checkpoint:       checkpoint,
+	}
+}
+
+// testRestoreObjects returns the objects of keys, to be restored as they are
+func testRestoreObjects(keys ...string) []restoreObject {
+	var objects []restoreObject
+	for _, key := range keys {
+		objects = append(objects, restoreObject{Key: key, Topic: "orders", Target: "orders", Partition: -1, StartOffset: -1})
+	}
+	return objects
+}
+
+func TestListObjectsForDate(t *testing.T) {
+	const prefix = "topics/orders/year=2020/month=04/day=27/"
+	objects := make(map[string]*fakeS3Object)
+	for partition := 0; partition < 3; partition++ {
+		for offset := 0; offset < 3; offset++ {
+			objects[fmt.Sprintf("%sorders+%d+%010d.json", prefix, partition, offset*100)] = &fakeS3Object{body: "1\n"}
+		}
+	}
+	objects[prefix+"_SUCCESS"] = &fakeS3Object{}
+	objects["topics/orders/year=2020/month=04/day=28/orders+0+0000000300.json"] = &fakeS3Object{body: "1\n"}
+
+	tests := []struct {
+		name     string
+		pageSize int
+		matcher  *regexp.Regexp
+		count    int
+		tokens   []string
+	}{
+		{name: "one page", pageSize: 1000, count: 10, tokens: []string{""}},
+		{name: "pages", pageSize: 4, count: 10, tokens: []string{"", "4", "8"}},
+		{name: "last page full", pageSize: 5, count: 10, tokens: []string{"", "5"}},
+		{name: "pages with a matcher", pageSize: 3, matcher: regexp.MustCompile(`\+1\+\d+\.json$`), count: 3, tokens: []string{"", "3", "6", "9"}},
+	}
+
+	for _, test := range tests {
+		fake := newFakeS3(objects)
+		fake.pageSize = test.pageSize
+		server := httptest.NewServer(fake)
+		listed, err := listObjectsForDate(newTestS3Client(server), testBucket, prefix, test.matcher)
+		server.Close()
+		if err != nil {
+			t.Errorf("%s: listObjectsForDate: %v", test.name, err)
+			continue
+		}
+
+		var keys []string
+		for _, object := range listed {
+			key := aws.StringValue(object.Key)
+			if !strings.HasPrefix(key, prefix) || (test.matcher != nil && !test.matcher.MatchString(key)) {
+				t.Errorf("%s: listed %s", test.name, key)
+			}
+			keys = append(keys, key)
+		}
+		if len(keys) != test.count || !sort.StringsAreSorted(keys) {
+			t.Errorf("%s: listed %v, want %d keys sorted", test.name, keys, test.count)
+		}
+		if !reflect.DeepEqual(fake.tokens, test.tokens) {
+			t.Errorf("%s: listed with the continuation tokens %q, want %q", test.name, fake.tokens, test.tokens)
+		}
+	}
+}
+
+func TestFailedObjectKeepsCheckpoint(t *testing.T) {
+	fake := newFakeS3(map[string]*fakeS3Object{
+		"a.json": {body: "1\n2\n"},
+		"b.json": {fail: true},
+		"c.json": {body: "3\n"},
+	})
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	store := &memoryCheckpointStore{state: &checkpointState{Restore: "restore", Completed: []string{"done.json"}}}
+	checkpoint, err := newCheckpointTracker(store, "restore", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The producer acknowledges every record right away
+	ctx := context.Background()
+	records := make(chan *restoreRecord)
+	downloadErrors := make(chan error, 1)
+	go func() {
+		downloadErrors <- downloadDateRange(ctx, newTestS3Client(server), testBucket, testRestoreObjects("a.json", "b.json", "c.json"), testStreamOptions(2, checkpoint), records)
+	}()
+	for record := range records {
+		checkpoint.acked(record)
+	}
+	downloadErr := <-downloadErrors
+	if downloadErr == nil {
+		t.Fatal("download of a missing object succeeded")
+	}
+
+	if interrupted := closeCheckpoint(ctx, checkpoint, downloadErr); !interrupted {
+		t.Errorf("restore stopped on a failed object isn't interrupted")
+	}
+	if store.removed || store.state == nil {
+		t.Fatalf("checkpoint was removed after a failed object")
+	}
+	if strings.Join(store.state.Completed, " ") != "a.json done.json" {
+		t.Errorf("completed objects are %v, want a.json and done.json", store.state.Completed)
+	}
+}
+
+func TestTruncatedObject(t *testing.T) {
+	fake := newFakeS3(map[string]*fakeS3Object{
+		"a.json": {body: testObjectLines("a", 4), short: 6},
+		"b.json": {body: testObjectLines("b", 1)},
+	})
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	store := &memoryCheckpointStore{}
+	checkpoint, err := newCheckpointTracker(store, "restore", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values, err := downloadTestObjects(context.Background(), server, testStreamOptions(1, checkpoint), []string{"a.json", "b.json"}, checkpoint.acked)
+	if err == nil {
+		t.Fatal("download of a truncated object succeeded")
+	}
+
+	// The records read before the body was cut were forwarded, and a resume carries on after them
+	if strings.Join(values, " ") != "a-1 a-2" {
+		t.Errorf("forwarded %v, want a-1 and a-2", values)
+	}
+	checkpoint.save()
+	if progress := store.state.InProgress["a.json"]; len(store.state.Completed) != 0 || progress == nil || progress.Watermark != 2 {
+		t.Errorf("saved %v and %v, want a.json in progress after line 2", store.state.Completed, store.state.InProgress)
+	}
+}
+
+// testObjectLines returns the body of an object of count lines, named after the object
+func testObjectLines(name string, count int) string {
+	var body strings.Builder
+	for line := 1; line <= count; line++ {
+		body.WriteString(name + "-" + strconv.Itoa(line) + "\n")
+	}
+	return body.String()
+}
+
+// downloadTestObjects runs downloadObjectList over the objects of keys, and returns the values of the records
+// it forwarded. received is called with every record, and may be nil.
+func downloadTestObjects(ctx context.Context, server *httptest.Server, options streamOptions, keys []string, received func(*restoreRecord)) ([]string, error) {
+	records := make(chan *restoreRecord)
+	downloadErrors := make(chan error, 1)
+	go func() {
+		defer close(records)
+		downloadErrors <- downloadObjectList(ctx, newTestS3Client(server), testBucket, testRestoreObjects(keys...), options, records)
+	}()
+
+	var values []string
+	for record := range records {
+		values = append(values, record.Value)
+		if received != nil {
+			received(record)
+		}
+	}
+	return values, <-downloadErrors
+}
+
+// waitGoroutines waits for the goroutines started since before to stop, and reports whether they did
+func waitGoroutines(before int) bool {
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if runtime.NumGoroutine() <= before {
+			return true
+		}
+	}
+	return false
+}
+
+func TestDownloadObjectList(t *testing.T) {
+	tests := []struct {
+		name    string
+		workers int
+		// lines and delays of the objects, in restore order
+		lines  []int
+		delays []time.Duration
+		failed int
+		// cancelAfter cancels the download once that many records were received
+		cancelAfter int
+		want        int
+		fails       bool
+	}{
+		{
+			name:    "mixed sizes and latencies",
+			workers: 3,
+			lines:   []int{40, 0, 3, 200, 1, 25, 7, 60},
+			delays:  []time.Duration{40, 5, 30, 0, 20, 10, 0, 15},
+			failed:  -1,
+			want:    8,
+		},
+		{name: "single worker", workers: 1, lines: []int{5, 10, 1}, delays: []time.Duration{10, 0, 5}, failed: -1, want: 3},
+		{name: "more workers than objects", workers: 8, lines: []int{3, 2}, delays: []time.Duration{20, 0}, failed: -1, want: 2},
+		{name: "failed object", workers: 2, lines: []int{4, 4, 4, 4}, delays: []time.Duration{20, 0, 0, 0}, failed: 2, want: 2, fails: true},
+		{name: "cancelled", workers: 2, lines: []int{50, 50, 50}, delays: []time.Duration{0, 0, 0}, failed: -1, cancelAfter: 60, want: 3, fails: true},
+	}
+
+	for _, test := range tests {
+		before := runtime.NumGoroutine()
+		objects := make(map[string]*fakeS3Object)
+		var keys []string
+		var expected []string
+		for i, lines := range test.lines {
+			key := fmt.Sprintf("object-%d.json", i)
+			keys = append(keys, key)
+			objects[key] = &fakeS3Object{body: testObjectLines(key, lines), delay: test.delays[i] * time.Millisecond, fail: i == test.failed}
+			if i < test.want {
+				for line := 1; line <= lines; line++ {
+					expected = append(expected, key+"-"+strconv.Itoa(line))
+				}
+			}
+		}
+		fake := newFakeS3(objects)
+		server := httptest.NewServer(fake)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		received := 0
+		values, err := downloadTestObjects(ctx, server, testStreamOptions(test.workers, nil), keys, func(*restoreRecord) {
+			if received++; received == test.cancelAfter {
+				cancel()
+			}
+		})
+		cancel()
+		server.Close()
+
+		if test.fails != (err != nil) {
+			t.Errorf("%s: error is %v, want an error: %v", test.name, err, test.fails)
+		}
+		if test.cancelAfter > 0 {
+			// The records queued when ctx is cancelled may or may not be forwarded
+			if err != context.Canceled || len(values) < test.cancelAfter || len(values) == len(expected) {
+				t.Errorf("%s: forwarded %d records with %v after the cancel at %d", test.name, len(values), err, test.cancelAfter)
+			}
+			expected = expected[:len(values)]
+		}
+		if strings.Join(values, " ") != strings.Join(expected, " ") {
+			t.Errorf("%s: records are %v, want %v", test.name, values, expected)
+		}
+		if fake.maxOpen > test.workers {
+			t.Errorf("%s: %d GetObject calls were open at once with %d workers", test.name, fake.maxOpen, test.workers)
+		}
+		if !waitGoroutines(before) {
+			t.Errorf("%s: %d goroutines are left running, %d were before", test.name, runtime.NumGoroutine(), before)
+		}
+	}
+}
+
+func TestDownloadObjectListIdle(t *testing.T) {
+	keys := []string{"a.json", "b.json", "c.json"}
+	objects := make(map[string]*fakeS3Object)
+	var expected []string
+	for _, key := range keys {
+		objects[key] = &fakeS3Object{body: testObjectLines(key, 4)}
+		for line := 1; line <= 4; line++ {
+			expected = append(expected, key+"-"+strconv.Itoa(line))
+		}
+	}
+	fake := newFakeS3(objects)
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	// The queues only hold a record, and the producer is slower than the idle limit
+	options := testStreamOptions(3, nil)
+	options.recordsQueueSize = 1
+	options.idleLimit = 5 * time.Millisecond
+	values, err := downloadTestObjects(context.Background(), server, options, keys, func(*restoreRecord) {
+		time.Sleep(10 * time.Millisecond)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(values, " ") != strings.Join(expected, " ") {
+		t.Errorf("records are %v, want %v", values, expected)
+	}
+	if fake.gets <= len(keys) {
+		t.Errorf("%d GetObject calls for %d objects, want the idle objects read again", fake.gets, len(keys))
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+)
+
+const adminRatePath = "/rate"
+
+// adminServer serves the HTTP endpoints an operator uses to follow and steer a running restore
+type adminServer struct {
+	mux    *http.ServeMux
+	server *http.Server
+}
+
+func newAdminServer(address string) *adminServer {
+	mux := http.NewServeMux()
+	return &adminServer{mux: mux, server: &http.Server{Addr: address, Handler: mux}}
+}
+
+// handle registers the handler of an endpoint
+func (s *adminServer) handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+// start listens on the admin address, and serves the endpoints in the background
+func (s *adminServer) start() error {
+	listener, err := net.Listen("tcp", s.server.Addr)
+	if err != nil {
+		return fmt.Errorf("error listening on admin address %s: %v", s.server.Addr, err)
+	}
+	go func() {
+		if err := s.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			logger.error(componentMain, fmt.Sprintf("Admin server stopped: %v", err))
+		}
+	}()
+	logger.info(componentMain, fmt.Sprintf("Admin server listening on %s", listener.Addr()))
+	return nil
+}
+
+func (s *adminServer) Close() error {
+	return s.server.Close()
+}
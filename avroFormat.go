@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/linkedin/goavro/v2"
+)
+
+// The Confluent wire format is a zero magic byte, the 4 bytes big-endian schema id, and the Avro binary encoding
+const (
+	wireFormatMagic      = 0
+	wireFormatHeaderSize = 5
+)
+
+// avroObject reads the records of an Avro object container file one at a time.
+// Only the block being read is held in memory, whatever the size of the object.
+type avroObject struct {
+	*objectBody
+	ocf *goavro.OCFReader
+	// schemaID is the id the writer schema of the object is registered with
+	schemaID int
+	records  int64
+}
+
+// openAvroObject starts reading a container file. When subject isn't empty, the writer schema of the object
+// is registered under it, for its records to be encoded.
+func openAvroObject(ctx context.Context, s3Client *s3.S3, bucket string, key string, options streamOptions, subject string) (*avroObject, error) {
+	body, err := openObjectBody(ctx, s3Client, bucket, key, options)
+	if err != nil {
+		return nil, err
+	}
+	ocf, err := goavro.NewOCFReader(body.decompressed)
+	if err != nil {
+		body.Close()
+		return nil, fmt.Errorf("error reading Avro container file %s: %v", key, err)
+	}
+
+	object := &avroObject{objectBody: body, ocf: ocf}
+	if subject != "" {
+		object.schemaID, err = options.registry.register(subject, ocf.Codec().Schema())
+		if err != nil {
+			body.Close()
+			return nil, err
+		}
+	}
+	return object, nil
+}
+
+// openSideAvro opens the side object of an object, or returns nil when there's none
+func openSideAvro(ctx context.Context, s3Client *s3.S3, bucket string, key string, options streamOptions, subject string) (*avroObject, error) {
+	if key == "" {
+		return nil, nil
+	}
+	return openAvroObject(ctx, s3Client, bucket, key, options, subject)
+}
+
+// next returns the next record of the container file decoded into its native Go form, and io.EOF at its end
+func (o *avroObject) next() (interface{}, error) {
+	if !o.ocf.Scan() {
+		if err := o.ocf.Err(); err != nil {
+			return nil, fmt.Errorf("error reading %s after record %d: %v", o.key, o.records, err)
+		}
+		return nil, io.EOF
+	}
+	native, err := o.ocf.Read()
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s after record %d: %v", o.key, o.records, err)
+	}
+	o.records++
+	return native, nil
+}
+
+// encode returns a record in the Confluent wire format, under the registered id of the object schema
+func (o *avroObject) encode(native interface{}) ([]byte, error) {
+	header := make([]byte, wireFormatHeaderSize)
+	header[0] = wireFormatMagic
+	binary.BigEndian.PutUint32(header[1:], uint32(o.schemaID))
+	encoded, err := o.ocf.Codec().BinaryFromNative(header, native)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding %s record %d: %v", o.key, o.records, err)
+	}
+	return encoded, nil
+}
+
+// verify reads whatever follows the last block before checking the length of the object
+func (o *avroObject) verify() error {
+	if _, err := io.Copy(ioutil.Discard, o.decompressed); err != nil {
+		return fmt.Errorf("error reading %s: %v", o.key, err)
+	}
+	return o.objectBody.verify()
+}
+
+// nextSideAvro returns the record of a side object that matches the current value record
+func nextSideAvro(side *avroObject) (interface{}, error) {
+	native, err := side.next()
+	if err == io.EOF {
+		return nil, fmt.Errorf("side object %s has fewer records than its values", side.key)
+	}
+	return native, err
+}
+
+// verifySideAvro checks that a side object ended together with its values, and was read completely
+func verifySideAvro(side *avroObject) error {
+	if side == nil {
+		return nil
+	}
+	if _, err := side.next(); err != io.EOF {
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("side object %s has more records than its values", side.key)
+	}
+	return side.verify()
+}
+
+// streamAvroObject reads the records of a container file, together with its keys and headers side objects, and
+// sends every record with the sender. Values and keys are re-encoded in the Confluent wire format,
+// with their schemas registered under the <target>-value and <target>-key subjects.
+func streamAvroObject(ctx context.Context, s3Client *s3.S3, bucket string, object restoreObject, options streamOptions, sender *recordSender) error {
+	if options.registry == nil {
+		return fmt.Errorf("object %s is an Avro container file, and no schema registry is configured for its schema", object.Key)
+	}
+
+	values, err := openAvroObject(ctx, s3Client, bucket, object.Key, options, object.Target+subjectValueSuffix)
+	if err != nil {
+		return err
+	}
+	defer values.Close()
+
+	keys, err := openSideAvro(ctx, s3Client, bucket, object.KeysKey, options, object.Target+subjectKeySuffix)
+	if err != nil {
+		return err
+	}
+	if keys != nil {
+		defer keys.Close()
+	}
+	// Headers aren't registered, they're restored as Kafka headers
+	headers, err := openSideAvro(ctx, s3Client, bucket, object.HeadersKey, options, "")
+	if err != nil {
+		return err
+	}
+	if headers != nil {
+		defer headers.Close()
+	}
+
+	recordsCount := 0
+	for index := int64(0); ; index++ {
+		native, err := values.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		record := newRestoreRecord(object, index, values.records)
+		value, err := values.encode(native)
+		if err != nil {
+			return err
+		}
+		if len(value) > options.maxRecordBytes {
+			return fmt.Errorf("record %d of %s is longer than %d bytes", values.records, object.Key, options.maxRecordBytes)
+		}
+		record.Value = string(value)
+
+		if keys != nil {
+			keyNative, err := nextSideAvro(keys)
+			if err != nil {
+				return err
+			}
+			key, err := keys.encode(keyNative)
+			if err != nil {
+				return err
+			}
+			record.Key = string(key)
+		}
+		if headers != nil {
+			headersNative, err := nextSideAvro(headers)
+			if err != nil {
+				return err
+			}
+			if record.Headers, err = avroHeaders(headersNative); err != nil {
+				return fmt.Errorf("invalid headers of %s record %d: %v", object.Key, values.records, err)
+			}
+		}
+
+		if object.filter && options.timestamps != nil {
+			if timestamp, ok := options.timestamps.extractNative(native); ok && !options.window.contains(timestamp) {
+				continue
+			}
+		}
+		if options.checkpoint.skip(record) {
+			continue
+		}
+		if err := sender.send(ctx, record); err != nil {
+			return err
+		}
+		recordsCount++
+	}
+
+	for _, side := range []*avroObject{keys, headers} {
+		if err := verifySideAvro(side); err != nil {
+			return err
+		}
+	}
+	if err := values.verify(); err != nil {
+		return err
+	}
+	logger.info(componentS3, fmt.Sprintf("Read %d records to restore (%d Avro records, %d bytes) from %s", recordsCount, values.records, values.counter.bytes, object.Key))
+	return nil
+}
+
+// avroHeaders converts the headers of a record, an array of key and value records, into Kafka headers.
+// Text and bytes values are kept as they are, and any other value is written as JSON.
+func avroHeaders(native interface{}) ([]KafkaHeader, error) {
+	native = unwrapAvroUnion(native)
+	if native == nil {
+		return nil, nil
+	}
+	items, ok := native.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected an array of headers, got %T", native)
+	}
+
+	headers := make([]KafkaHeader, 0, len(items))
+	for _, item := range items {
+		fields, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("expected a header record, got %T", item)
+		}
+		key, _ := unwrapAvroUnion(fields["key"]).(string)
+		header := KafkaHeader{Key: key}
+		switch value := unwrapAvroUnion(fields["value"]).(type) {
+		case nil:
+		case string:
+			header.Value = value
+		case []byte:
+			header.Value = string(value)
+		default:
+			data, err := json.Marshal(value)
+			if err != nil {
+				return nil, err
+			}
+			header.Value = string(data)
+		}
+		headers = append(headers, header)
+	}
+	return headers, nil
+}
+
+// unwrapAvroUnion returns the value of a union, which goavro decodes into a map of the type name to the value
+func unwrapAvroUnion(native interface{}) interface{} {
+	if union, ok := native.(map[string]interface{}); ok && len(union) == 1 {
+		for _, value := range union {
+			return value
+		}
+	}
+	return native
+}
+
+// extractNative returns the timestamp of a decoded Avro record, and false when it has no readable timestamp.
+// Unions on the path of the field are unwrapped.
+func (e *timestampExtractor) extractNative(record interface{}) (time.Time, bool) {
+	value := record
+	for _, field := range e.path {
+		fields, ok := value.(map[string]interface{})
+		if !ok {
+			return time.Time{}, false
+		}
+		next, ok := fields[field]
+		if !ok {
+			if fields, ok = unwrapAvroUnion(fields).(map[string]interface{}); ok {
+				next, ok = fields[field]
+			}
+			if !ok {
+				return time.Time{}, false
+			}
+		}
+		value = next
+	}
+
+	switch value := unwrapAvroUnion(value).(type) {
+	case time.Time:
+		return value.UTC(), true
+	case int64:
+		return e.parse([]byte(strconv.FormatInt(value, 10)))
+	case int32:
+		return e.parse([]byte(strconv.FormatInt(int64(value), 10)))
+	case string:
+		text, _ := json.Marshal(value)
+		return e.parse(text)
+	}
+	return time.Time{}, false
+}
+
+// countAvroRecords counts the records of the whole blocks at the start of a container file
+func countAvroRecords(reader io.Reader) int64 {
+	ocf, err := goavro.NewOCFReader(reader)
+	if err != nil {
+		return 0
+	}
+	var records int64
+	for ocf.Scan() {
+		if _, err := ocf.Read(); err != nil {
+			break
+		}
+		records++
+	}
+	return records
+}
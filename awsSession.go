@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// defaultAWSRegion is the region of the session when neither the configuration, the environment nor the
+// profile has one
+const defaultAWSRegion = "us-west-1"
+
+// s3SessionOptions configures the session of the S3 clients
+type s3SessionOptions struct {
+	// region is empty to take it from AWS_REGION, AWS_DEFAULT_REGION or the profile
+	region   string
+	endpoint string
+	// profile is the shared credentials and config profile, and is empty for AWS_PROFILE or the default one
+	profile string
+	// accessKey and secretKey are static credentials, which override the default credential chain.
+	// sessionToken goes with them for temporary credentials.
+	accessKey    string
+	secretKey    string
+	sessionToken string
+
+	disableSSL     bool
+	forcePathStyle bool
+}
+
+// newS3Session returns the session of the S3 clients. Without static keys, the credentials come from the
+// default chain of the SDK: the environment, the shared credentials file, a web identity token, and the
+// container or instance role.
+func newS3Session(options s3SessionOptions) (*session.Session, error) {
+	if (options.accessKey == "") != (options.secretKey == "") {
+		return nil, fmt.Errorf("static S3 credentials need both an access key and a secret key")
+	}
+	if options.sessionToken != "" && options.accessKey == "" {
+		return nil, fmt.Errorf("an S3 session token needs the access key and secret key it was issued with")
+	}
+
+	// The region is resolved first, since the web identity and assume role credentials call STS in it
+	region, err := resolveAWSRegion(options)
+	if err != nil {
+		return nil, err
+	}
+	config := aws.NewConfig().
+		WithRegion(region).
+		WithEndpoint(options.endpoint).
+		WithDisableSSL(options.disableSSL).
+		WithS3ForcePathStyle(options.forcePathStyle)
+	if options.accessKey != "" {
+		config.WithCredentials(credentials.NewStaticCredentials(options.accessKey, options.secretKey, options.sessionToken))
+	}
+
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config:            *config,
+		Profile:           options.profile,
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating the S3 session: %v", err)
+	}
+
+	// The credentials are resolved once here, so the log tells where they came from
+	value, err := sess.Config.Credentials.Get()
+	if err != nil {
+		logger.warning(componentS3, fmt.Sprintf("No S3 credentials were found, the S3 requests will fail: %v", err))
+	} else {
+		logger.info(componentS3, fmt.Sprintf("Using S3 credentials of %s in region %s", value.ProviderName, aws.StringValue(sess.Config.Region)))
+	}
+	return sess, nil
+}
+
+// resolveAWSRegion returns the region of the configuration, else the one of AWS_REGION or AWS_DEFAULT_REGION,
+// else the one of the profile, else defaultAWSRegion
+func resolveAWSRegion(options s3SessionOptions) (string, error) {
+	if options.region != "" {
+		return options.region, nil
+	}
+	for _, variable := range []string{"AWS_REGION", "AWS_DEFAULT_REGION"} {
+		if region := os.Getenv(variable); region != "" {
+			return region, nil
+		}
+	}
+
+	// The shared config is only read here, the credentials of the profile aren't resolved yet
+	profile, err := session.NewSessionWithOptions(session.Options{
+		Profile:           options.profile,
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return "", fmt.Errorf("error reading the region of the AWS profile: %v", err)
+	}
+	if region := aws.StringValue(profile.Config.Region); region != "" {
+		return region, nil
+	}
+	return defaultAWSRegion, nil
+}
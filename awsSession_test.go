@@ -0,0 +1,146 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// setTestEnv sets an environment variable for the rest of the test, and unsets it when value is empty
+func setTestEnv(t *testing.T, name string, value string) {
+	previous, ok := os.LookupEnv(name)
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(name, previous)
+		} else {
+			os.Unsetenv(name)
+		}
+	})
+	if value == "" {
+		os.Unsetenv(name)
+	} else {
+		os.Setenv(name, value)
+	}
+}
+
+// isolateAWSEnv points the SDK at a shared config file of the given content and an empty credentials file,
+// and clears the environment variables that would select a region, a profile or credentials
+func isolateAWSEnv(t *testing.T, config string) {
+	dir, err := ioutil.TempDir("", "aws")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	if err := ioutil.WriteFile(filepath.Join(dir, "config"), []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "credentials"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	setTestEnv(t, "AWS_CONFIG_FILE", filepath.Join(dir, "config"))
+	setTestEnv(t, "AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "credentials"))
+	setTestEnv(t, "AWS_EC2_METADATA_DISABLED", "true")
+	for _, name := range []string{"AWS_REGION", "AWS_DEFAULT_REGION", "AWS_PROFILE", "AWS_DEFAULT_PROFILE", "AWS_SDK_LOAD_CONFIG",
+		"AWS_ACCESS_KEY_ID", "AWS_ACCESS_KEY", "AWS_SECRET_ACCESS_KEY", "AWS_SECRET_KEY", "AWS_SESSION_TOKEN",
+		"AWS_WEB_IDENTITY_TOKEN_FILE", "AWS_ROLE_ARN", "AWS_CONTAINER_CREDENTIALS_RELATIVE_URI", "AWS_CONTAINER_CREDENTIALS_FULL_URI"} {
+		setTestEnv(t, name, "")
+	}
+}
+
+const testAWSConfig = `[default]
+region = eu-west-3
+
+[profile restore]
+region = ap-south-1
+
+[profile no-region]
+output = json
+`
+
+func TestResolveAWSRegion(t *testing.T) {
+	tests := []struct {
+		name          string
+		config        string
+		region        string
+		profile       string
+		awsRegion     string
+		defaultRegion string
+		want          string
+	}{
+		{name: "configuration", config: testAWSConfig, region: "eu-central-1", profile: "restore", awsRegion: "us-east-2", defaultRegion: "us-west-2", want: "eu-central-1"},
+		{name: "AWS_REGION", config: testAWSConfig, profile: "restore", awsRegion: "us-east-2", defaultRegion: "us-west-2", want: "us-east-2"},
+		{name: "AWS_DEFAULT_REGION", config: testAWSConfig, profile: "restore", defaultRegion: "us-west-2", want: "us-west-2"},
+		{name: "profile", config: testAWSConfig, profile: "restore", want: "ap-south-1"},
+		{name: "default profile", config: testAWSConfig, want: "eu-west-3"},
+		{name: "profile without a region", config: testAWSConfig, profile: "no-region", want: defaultAWSRegion},
+		{name: "no shared config", config: "", want: defaultAWSRegion},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			isolateAWSEnv(t, test.config)
+			setTestEnv(t, "AWS_REGION", test.awsRegion)
+			setTestEnv(t, "AWS_DEFAULT_REGION", test.defaultRegion)
+
+			region, err := resolveAWSRegion(s3SessionOptions{region: test.region, profile: test.profile})
+			if err != nil || region != test.want {
+				t.Errorf("resolveAWSRegion = %q, %v, want %q", region, err, test.want)
+			}
+		})
+	}
+}
+
+func TestNewS3Session(t *testing.T) {
+	tests := []struct {
+		name     string
+		options  s3SessionOptions
+		env      map[string]string
+		region   string
+		provider string
+		fails    bool
+	}{
+		{name: "access key only", options: s3SessionOptions{accessKey: "AKID"}, fails: true},
+		{name: "secret key only", options: s3SessionOptions{secretKey: "SECRET"}, fails: true},
+		{name: "session token only", options: s3SessionOptions{sessionToken: "TOKEN"}, fails: true},
+		{name: "session token and secret key", options: s3SessionOptions{secretKey: "SECRET", sessionToken: "TOKEN"}, fails: true},
+		{name: "static keys", options: s3SessionOptions{accessKey: "AKID", secretKey: "SECRET", region: "eu-central-1"}, region: "eu-central-1", provider: "StaticProvider"},
+		{name: "temporary keys", options: s3SessionOptions{accessKey: "AKID", secretKey: "SECRET", sessionToken: "TOKEN"}, region: "eu-west-3", provider: "StaticProvider"},
+		{
+			name:     "environment",
+			env:      map[string]string{"AWS_ACCESS_KEY_ID": "AKID", "AWS_SECRET_ACCESS_KEY": "SECRET", "AWS_DEFAULT_REGION": "us-west-2"},
+			region:   "us-west-2",
+			provider: "EnvConfigCredentials",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			isolateAWSEnv(t, testAWSConfig)
+			for name, value := range test.env {
+				setTestEnv(t, name, value)
+			}
+
+			sess, err := newS3Session(test.options)
+			if test.fails {
+				if err == nil {
+					t.Errorf("newS3Session succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if region := aws.StringValue(sess.Config.Region); region != test.region {
+				t.Errorf("region is %q, want %q", region, test.region)
+			}
+			value, err := sess.Config.Credentials.Get()
+			if err != nil || value.ProviderName != test.provider || value.AccessKeyID != "AKID" {
+				t.Errorf("credentials are %s of %s, %v, want AKID of %s", value.AccessKeyID, value.ProviderName, err, test.provider)
+			}
+		})
+	}
+}
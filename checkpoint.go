@@ -0,0 +1,387 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+const (
+	s3LocationScheme          = "s3://"
+	defaultCheckpointInterval = 10 * time.Second
+)
+
+// objectProgress is the durable progress inside an object that wasn't completed yet
+type objectProgress struct {
+	// Watermark is the last line up to which every record was acknowledged
+	Watermark int64 `json:"watermark"`
+	// Acked are the lines after the watermark that were already acknowledged
+	Acked []int64 `json:"acked,omitempty"`
+}
+
+// checkpointState is the durable progress of a restore
+type checkpointState struct {
+	Restore    string                     `json:"restore"`
+	Completed  []string                   `json:"completed"`
+	InProgress map[string]*objectProgress `json:"in_progress"`
+	UpdatedAt  time.Time                  `json:"updated_at"`
+}
+
+// checkpointStore persists the checkpoint state
+type checkpointStore interface {
+	// load returns the saved state, or nil when there's none
+	load() (*checkpointState, error)
+	save(state *checkpointState) error
+	remove() error
+	String() string
+}
+
+// fileCheckpointStore keeps the checkpoint in a local file
+type fileCheckpointStore struct {
+	path string
+}
+
+func (s *fileCheckpointStore) load() (*checkpointState, error) {
+	data, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	state := &checkpointState{}
+	return state, json.Unmarshal(data, state)
+}
+
+// save writes the state into a temporary file first, so a crash never leaves a partial checkpoint
+func (s *fileCheckpointStore) save(state *checkpointState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	temp := s.path + ".tmp"
+	if err := ioutil.WriteFile(temp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(temp, s.path)
+}
+
+func (s *fileCheckpointStore) remove() error {
+	err := os.Remove(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (s *fileCheckpointStore) String() string {
+	return s.path
+}
+
+// s3CheckpointStore keeps the checkpoint in an S3 object
+type s3CheckpointStore struct {
+	client *s3.S3
+	bucket string
+	key    string
+}
+
+func (s *s3CheckpointStore) load() (*checkpointState, error) {
+	output, err := s.client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer output.Body.Close()
+
+	state := &checkpointState{}
+	return state, json.NewDecoder(output.Body).Decode(state)
+}
+
+func (s *s3CheckpointStore) save(state *checkpointState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.PutObject(&s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(s.key),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String("application/json"),
+	})
+	return err
+}
+
+func (s *s3CheckpointStore) remove() error {
+	_, err := s.client.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key),
+	})
+	return err
+}
+
+func (s *s3CheckpointStore) String() string {
+	return s3LocationScheme + s.bucket + "/" + s.key
+}
+
+// newCheckpointStore returns the store of a location, which is either s3://bucket/key or a local file path
+func newCheckpointStore(s3Client *s3.S3, location string) (checkpointStore, error) {
+	if !strings.HasPrefix(location, s3LocationScheme) {
+		if dir := filepath.Dir(location); dir != "" {
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return nil, err
+			}
+		}
+		return &fileCheckpointStore{path: location}, nil
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(location, s3LocationScheme), "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("checkpoint location %q must look like s3://bucket/key", location)
+	}
+	return &s3CheckpointStore{client: s3Client, bucket: parts[0], key: parts[1]}, nil
+}
+
+// objectTracker follows the records of one object between the producer and the acknowledgements
+type objectTracker struct {
+	// watermark and acked start from the saved progress and move forward with the acknowledgements
+	watermark int64
+	acked     map[int64]bool
+	// resumeAcked are the lines after the saved watermark that were acknowledged before the restart
+	resumeAcked map[int64]bool
+	// sent are the lines produced and not yet under the watermark, in the order they were sent
+	sent []int64
+	// read is set once every record of the object was sent
+	read bool
+}
+
+// checkpointTracker records which records were acknowledged, and periodically saves it so an
+// interrupted restore resumes without producing them again. A nil tracker disables checkpoints.
+type checkpointTracker struct {
+	mutex     sync.Mutex
+	store     checkpointStore
+	restore   string
+	interval  time.Duration
+	completed map[string]bool
+	objects   map[string]*objectTracker
+	lastSave  time.Time
+}
+
+// newCheckpointTracker loads the saved progress of the restore identified by restore.
+// A checkpoint of a different restore is ignored.
+func newCheckpointTracker(store checkpointStore, restore string, interval time.Duration) (*checkpointTracker, error) {
+	tracker := &checkpointTracker{
+		store:     store,
+		restore:   restore,
+		interval:  interval,
+		completed: make(map[string]bool),
+		objects:   make(map[string]*objectTracker),
+		lastSave:  time.Now(),
+	}
+
+	state, err := store.load()
+	if err != nil {
+		return nil, fmt.Errorf("error loading checkpoint from %s: %v", store, err)
+	}
+	if state == nil {
+		return tracker, nil
+	}
+	if state.Restore != restore {
+		logger.warning(componentMain, fmt.Sprintf("Ignoring checkpoint %s of another restore: %s", store, state.Restore))
+		return tracker, nil
+	}
+
+	for _, key := range state.Completed {
+		tracker.completed[key] = true
+	}
+	for key, progress := range state.InProgress {
+		object := &objectTracker{watermark: progress.Watermark, acked: make(map[int64]bool), resumeAcked: make(map[int64]bool)}
+		for _, line := range progress.Acked {
+			object.resumeAcked[line] = true
+		}
+		tracker.objects[key] = object
+	}
+	logger.info(componentMain, fmt.Sprintf("Resuming from checkpoint %s saved at %v: %d objects completed, %d in progress",
+		store, state.UpdatedAt, len(state.Completed), len(state.InProgress)))
+	return tracker, nil
+}
+
+// object returns the tracker of an object, and creates it when needed. Must be called with the mutex held.
+func (t *checkpointTracker) object(key string) *objectTracker {
+	object, ok := t.objects[key]
+	if !ok {
+		object = &objectTracker{acked: make(map[int64]bool)}
+		t.objects[key] = object
+	}
+	return object
+}
+
+// isCompleted reports whether every record of an object was already acknowledged
+func (t *checkpointTracker) isCompleted(key string) bool {
+	if t == nil {
+		return false
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.completed[key]
+}
+
+// skip reports whether a record was already acknowledged before the restart
+func (t *checkpointTracker) skip(record *restoreRecord) bool {
+	if t == nil {
+		return false
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.completed[record.ObjectKey] {
+		return true
+	}
+	object, ok := t.objects[record.ObjectKey]
+	return ok && (record.Line <= object.watermark || object.resumeAcked[record.Line])
+}
+
+// sent records that a record is about to be produced
+func (t *checkpointTracker) sent(record *restoreRecord) {
+	if t == nil {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	object := t.object(record.ObjectKey)
+	object.sent = append(object.sent, record.Line)
+}
+
+// objectRead records that every record of an object was sent
+func (t *checkpointTracker) objectRead(key string) {
+	if t == nil {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	object := t.object(key)
+	object.read = true
+	t.advance(key, object)
+}
+
+// acked records the acknowledgement of a record, and saves the checkpoint when it's due
+func (t *checkpointTracker) acked(record *restoreRecord) {
+	if t == nil {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	object := t.object(record.ObjectKey)
+	object.acked[record.Line] = true
+	t.advance(record.ObjectKey, object)
+
+	if time.Since(t.lastSave) >= t.interval {
+		t.saveLocked()
+	}
+}
+
+// advance moves the watermark of an object over the acknowledged lines at the head of the sent queue.
+// Must be called with the mutex held.
+func (t *checkpointTracker) advance(key string, object *objectTracker) {
+	for len(object.sent) > 0 && object.acked[object.sent[0]] {
+		delete(object.acked, object.sent[0])
+		object.watermark = object.sent[0]
+		object.sent = object.sent[1:]
+	}
+
+	if object.read && len(object.sent) == 0 {
+		t.completed[key] = true
+		delete(t.objects, key)
+	}
+}
+
+// save writes the current progress into the store
+func (t *checkpointTracker) save() {
+	if t == nil {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.saveLocked()
+}
+
+// saveLocked writes the current progress into the store. Must be called with the mutex held.
+func (t *checkpointTracker) saveLocked() {
+	state := &checkpointState{
+		Restore:    t.restore,
+		InProgress: make(map[string]*objectProgress),
+		UpdatedAt:  time.Now().UTC(),
+	}
+	for key := range t.completed {
+		state.Completed = append(state.Completed, key)
+	}
+	sort.Strings(state.Completed)
+
+	for key, object := range t.objects {
+		progress := &objectProgress{Watermark: object.watermark}
+		for line := range object.acked {
+			progress.Acked = append(progress.Acked, line)
+		}
+		for line := range object.resumeAcked {
+			if line > object.watermark {
+				progress.Acked = append(progress.Acked, line)
+			}
+		}
+		sort.Slice(progress.Acked, func(i, j int) bool { return progress.Acked[i] < progress.Acked[j] })
+		state.InProgress[key] = progress
+	}
+
+	t.lastSave = time.Now()
+	if err := t.store.save(state); err != nil {
+		logger.error(componentMain, fmt.Sprintf("Error saving checkpoint to %s: %v", t.store, err))
+	}
+}
+
+// finish saves the final progress once every object was read, and removes the checkpoint when
+// every record of the restore was acknowledged
+func (t *checkpointTracker) finish() {
+	if t == nil {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if len(t.objects) > 0 {
+		t.saveLocked()
+		logger.warning(componentMain, fmt.Sprintf("Restore is incomplete, %d objects have unacknowledged records, checkpoint kept in %s", len(t.objects), t.store))
+		return
+	}
+	if err := t.store.remove(); err != nil {
+		logger.error(componentMain, fmt.Sprintf("Error removing checkpoint %s: %v", t.store, err))
+		return
+	}
+	logger.info(componentMain, fmt.Sprintf("Restore is complete, checkpoint %s removed", t.store))
+}
+
+// interrupt saves the progress of a restore that was stopped before reading every object, and keeps the
+// checkpoint so the next run resumes from it
+func (t *checkpointTracker) interrupt() {
+	if t == nil {
+		return
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.saveLocked()
+	logger.warning(componentMain, fmt.Sprintf("Restore was interrupted with %d objects completed and %d in progress, checkpoint kept in %s",
+		len(t.completed), len(t.objects), t.store))
+}
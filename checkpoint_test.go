@@ -0,0 +1,180 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// memoryCheckpointStore keeps the checkpoint in memory
+type memoryCheckpointStore struct {
+	state   *checkpointState
+	removed bool
+}
+
+func (s *memoryCheckpointStore) load() (*checkpointState, error) {
+	return s.state, nil
+}
+
+func (s *memoryCheckpointStore) save(state *checkpointState) error {
+	s.state = state
+	return nil
+}
+
+func (s *memoryCheckpointStore) remove() error {
+	s.state = nil
+	s.removed = true
+	return nil
+}
+
+func (s *memoryCheckpointStore) String() string {
+	return "memory"
+}
+
+func checkpointRecord(key string, line int64) *restoreRecord {
+	return &restoreRecord{ObjectKey: key, Line: line}
+}
+
+func TestCheckpointTrackerWatermark(t *testing.T) {
+	tests := []struct {
+		name      string
+		sent      []int64
+		acked     []int64
+		read      bool
+		watermark int64
+		pending   []int64
+		completed bool
+	}{
+		{name: "nothing acked", sent: []int64{1, 2, 3}, watermark: 0},
+		{name: "acked in order", sent: []int64{1, 2, 3}, acked: []int64{1, 2}, watermark: 2},
+		{name: "gap", sent: []int64{1, 2, 3}, acked: []int64{1, 3}, watermark: 1, pending: []int64{3}},
+		{name: "gap filled", sent: []int64{1, 2, 3}, acked: []int64{3, 2, 1}, watermark: 3},
+		{name: "lines skipped by the window", sent: []int64{4, 9}, acked: []int64{9}, watermark: 0, pending: []int64{9}},
+		{name: "read and acked", sent: []int64{1, 2}, acked: []int64{2, 1}, read: true, completed: true},
+		{name: "read with a gap", sent: []int64{1, 2}, acked: []int64{2}, read: true, watermark: 0, pending: []int64{2}},
+	}
+
+	const key = "topics/orders/orders+0+0000000000.json"
+	for _, test := range tests {
+		store := &memoryCheckpointStore{}
+		tracker, err := newCheckpointTracker(store, "restore", time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, line := range test.sent {
+			tracker.sent(checkpointRecord(key, line))
+		}
+		for _, line := range test.acked {
+			tracker.acked(checkpointRecord(key, line))
+		}
+		if test.read {
+			tracker.objectRead(key)
+		}
+		tracker.save()
+
+		if test.completed {
+			if !reflect.DeepEqual(store.state.Completed, []string{key}) || len(store.state.InProgress) != 0 {
+				t.Errorf("%s: saved %v and %v, want %s completed", test.name, store.state.Completed, store.state.InProgress, key)
+			}
+			continue
+		}
+		progress := store.state.InProgress[key]
+		if progress == nil {
+			t.Errorf("%s: %s isn't in progress", test.name, key)
+			continue
+		}
+		if progress.Watermark != test.watermark || !reflect.DeepEqual(progress.Acked, test.pending) {
+			t.Errorf("%s: saved watermark %d and acked %v, want %d and %v", test.name, progress.Watermark, progress.Acked, test.watermark, test.pending)
+		}
+	}
+}
+
+func TestCheckpointTrackerResume(t *testing.T) {
+	store := &memoryCheckpointStore{state: &checkpointState{
+		Restore:   "restore",
+		Completed: []string{"done.json"},
+		InProgress: map[string]*objectProgress{
+			"partial.json": {Watermark: 10, Acked: []int64{12, 14}},
+		},
+	}}
+	tracker, err := newCheckpointTracker(store, "restore", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		key  string
+		line int64
+		skip bool
+	}{
+		{key: "done.json", line: 1, skip: true},
+		{key: "partial.json", line: 1, skip: true},
+		{key: "partial.json", line: 10, skip: true},
+		{key: "partial.json", line: 11, skip: false},
+		{key: "partial.json", line: 12, skip: true},
+		{key: "partial.json", line: 13, skip: false},
+		{key: "partial.json", line: 14, skip: true},
+		{key: "partial.json", line: 15, skip: false},
+		{key: "new.json", line: 1, skip: false},
+	}
+	for _, test := range tests {
+		if skip := tracker.skip(checkpointRecord(test.key, test.line)); skip != test.skip {
+			t.Errorf("skip of %s line %d is %v, want %v", test.key, test.line, skip, test.skip)
+		}
+	}
+	if !tracker.isCompleted("done.json") || tracker.isCompleted("partial.json") {
+		t.Errorf("completed objects are wrong")
+	}
+
+	// The lines acknowledged before the restart stay in the checkpoint until the watermark passes them
+	tracker.sent(checkpointRecord("partial.json", 11))
+	tracker.acked(checkpointRecord("partial.json", 11))
+	tracker.save()
+	progress := store.state.InProgress["partial.json"]
+	if progress.Watermark != 11 || !reflect.DeepEqual(progress.Acked, []int64{12, 14}) {
+		t.Errorf("saved watermark %d and acked %v, want 11 and [12 14]", progress.Watermark, progress.Acked)
+	}
+}
+
+func TestCheckpointTrackerOtherRestore(t *testing.T) {
+	store := &memoryCheckpointStore{state: &checkpointState{Restore: "other", Completed: []string{"done.json"}}}
+	tracker, err := newCheckpointTracker(store, "restore", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tracker.skip(checkpointRecord("done.json", 1)) {
+		t.Errorf("the checkpoint of another restore was used")
+	}
+}
+
+func TestCheckpointTrackerFinish(t *testing.T) {
+	tests := []struct {
+		name    string
+		acked   []int64
+		removed bool
+	}{
+		{name: "complete", acked: []int64{1, 2}, removed: true},
+		{name: "incomplete", acked: []int64{2}, removed: false},
+	}
+
+	for _, test := range tests {
+		store := &memoryCheckpointStore{}
+		tracker, err := newCheckpointTracker(store, "restore", time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tracker.sent(checkpointRecord("a.json", 1))
+		tracker.sent(checkpointRecord("a.json", 2))
+		tracker.objectRead("a.json")
+		for _, line := range test.acked {
+			tracker.acked(checkpointRecord("a.json", line))
+		}
+		tracker.finish()
+		if store.removed != test.removed {
+			t.Errorf("%s: checkpoint removed is %v, want %v", test.name, store.removed, test.removed)
+		}
+		if !test.removed && (store.state == nil || store.state.InProgress["a.json"] == nil) {
+			t.Errorf("%s: checkpoint of a.json wasn't kept", test.name)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	compressionAuto   = "auto"
+	compressionNone   = "none"
+	compressionGzip   = "gzip"
+	compressionSnappy = "snappy"
+	compressionZstd   = "zstd"
+
+	// zstdMaxWindowBytes bounds the memory of a zstd decoder, whatever the object size
+	zstdMaxWindowBytes = 64 << 20
+)
+
+var (
+	gzipMagic   = []byte{0x1f, 0x8b}
+	zstdMagic   = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	snappyMagic = []byte("\xff\x06\x00\x00sNaPpY")
+
+	compressionExtensions = map[string]string{
+		".gz":     compressionGzip,
+		".gzip":   compressionGzip,
+		".snappy": compressionSnappy,
+		".sz":     compressionSnappy,
+		".zst":    compressionZstd,
+		".zstd":   compressionZstd,
+	}
+)
+
+// validateCompression checks a configured compression type
+func validateCompression(compression string) error {
+	switch compression {
+	case compressionAuto, compressionNone, compressionGzip, compressionSnappy, compressionZstd:
+		return nil
+	}
+	return fmt.Errorf("unknown compression %q, expected one of %s, %s, %s, %s or %s",
+		compression, compressionAuto, compressionNone, compressionGzip, compressionSnappy, compressionZstd)
+}
+
+// detectCompression returns the compression of an object from its extension, or else from its first bytes
+func detectCompression(key string, header []byte) string {
+	if compression, ok := compressionExtensions[strings.ToLower(path.Ext(key))]; ok {
+		return compression
+	}
+	switch {
+	case bytes.HasPrefix(header, gzipMagic):
+		return compressionGzip
+	case bytes.HasPrefix(header, zstdMagic):
+		return compressionZstd
+	case bytes.HasPrefix(header, snappyMagic):
+		return compressionSnappy
+	}
+	return compressionNone
+}
+
+// zstdReadCloser adapts a zstd decoder, whose Close doesn't return an error
+type zstdReadCloser struct {
+	*zstd.Decoder
+}
+
+func (r zstdReadCloser) Close() error {
+	r.Decoder.Close()
+	return nil
+}
+
+// newDecompressingReader decompresses an object stream as it's read. With the auto compression
+// the type is detected from the key of the object or its magic bytes.
+func newDecompressingReader(reader io.Reader, key string, compression string) (io.ReadCloser, error) {
+	buffered := bufio.NewReader(reader)
+	if compression == compressionAuto || compression == "" {
+		// A short or empty object simply has no magic bytes
+		header, _ := buffered.Peek(len(snappyMagic))
+		compression = detectCompression(key, header)
+	}
+
+	switch compression {
+	case compressionGzip:
+		gzipReader, err := gzip.NewReader(buffered)
+		if err == io.EOF {
+			return ioutil.NopCloser(buffered), nil
+		}
+		return gzipReader, err
+	case compressionSnappy:
+		return ioutil.NopCloser(snappy.NewReader(buffered)), nil
+	case compressionZstd:
+		decoder, err := zstd.NewReader(buffered, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(zstdMaxWindowBytes))
+		if err != nil {
+			return nil, err
+		}
+		return zstdReadCloser{decoder}, nil
+	}
+	return ioutil.NopCloser(buffered), nil
+}
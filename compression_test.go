@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// compressTestData compresses data with a compression type, framed the way the backup objects are
+func compressTestData(t *testing.T, compression string, data []byte) []byte {
+	var compressed bytes.Buffer
+	switch compression {
+	case compressionGzip:
+		writer := gzip.NewWriter(&compressed)
+		writer.Write(data)
+		writer.Close()
+	case compressionSnappy:
+		writer := snappy.NewBufferedWriter(&compressed)
+		writer.Write(data)
+		writer.Close()
+	case compressionZstd:
+		encoder, err := zstd.NewWriter(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		compressed.Write(encoder.EncodeAll(data, nil))
+		encoder.Close()
+	default:
+		compressed.Write(data)
+	}
+	return compressed.Bytes()
+}
+
+func TestNewDecompressingReader(t *testing.T) {
+	data := []byte(strings.Repeat(`{"id":1,"name":"order"}`+"\n", 500))
+
+	tests := []struct {
+		name string
+		// written is the compression of the object, and compression the configured one
+		written     string
+		key         string
+		compression string
+	}{
+		{name: "gzip by extension", written: compressionGzip, key: "a.json.gz", compression: compressionAuto},
+		{name: "gzip by magic bytes", written: compressionGzip, key: "a.json", compression: compressionAuto},
+		{name: "snappy by extension", written: compressionSnappy, key: "a.json.snappy", compression: compressionAuto},
+		{name: "snappy by magic bytes", written: compressionSnappy, key: "a.bin", compression: compressionAuto},
+		{name: "zstd by extension", written: compressionZstd, key: "a.json.ZST", compression: compressionAuto},
+		{name: "zstd by magic bytes", written: compressionZstd, key: "a.json", compression: ""},
+		{name: "gzip override", written: compressionGzip, key: "a.json.zst", compression: compressionGzip},
+		{name: "zstd override", written: compressionZstd, key: "a.json.gz", compression: compressionZstd},
+		{name: "plain", written: compressionNone, key: "a.json", compression: compressionAuto},
+		{name: "plain override", written: compressionNone, key: "a.json.gz", compression: compressionNone},
+		{name: "plain with gzip magic bytes", written: compressionGzip, key: "a.json.gz", compression: compressionNone},
+	}
+
+	for _, test := range tests {
+		compressed := compressTestData(t, test.written, data)
+		reader, err := newDecompressingReader(bytes.NewReader(compressed), test.key, test.compression)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		read, err := ioutil.ReadAll(reader)
+		reader.Close()
+
+		// Data that isn't decompressed is passed through as it is
+		want := data
+		if test.compression == compressionNone {
+			want = compressed
+		}
+		if err != nil || !bytes.Equal(read, want) {
+			t.Errorf("%s: read %d bytes with %v, want %d bytes", test.name, len(read), err, len(want))
+		}
+	}
+}
+
+func TestNewDecompressingReaderShortObjects(t *testing.T) {
+	tests := []struct {
+		key  string
+		data string
+	}{
+		{key: "empty.json", data: ""},
+		{key: "empty.json.gz", data: ""},
+		{key: "short.json", data: "{}"},
+	}
+
+	for _, test := range tests {
+		reader, err := newDecompressingReader(strings.NewReader(test.data), test.key, compressionAuto)
+		if err != nil {
+			t.Errorf("%s: %v", test.key, err)
+			continue
+		}
+		if read, err := ioutil.ReadAll(reader); err != nil || string(read) != test.data {
+			t.Errorf("%s: read %q, %v, want %q", test.key, read, err, test.data)
+		}
+	}
+}
+
+func TestNewDecompressingReaderZstdMemoryBound(t *testing.T) {
+	tests := []struct {
+		name string
+		// windowDescriptor is the exponent of the window size of the frame, above 1KB
+		windowDescriptor byte
+		fails            bool
+	}{
+		{name: "1KB window", windowDescriptor: 0},
+		{name: "1GB window", windowDescriptor: 20 << 3, fails: true},
+	}
+
+	for _, test := range tests {
+		// A frame with a single raw block of one byte
+		frame := append(append([]byte{}, zstdMagic...), 0x00, test.windowDescriptor, 0x09, 0x00, 0x00, 'x')
+		reader, err := newDecompressingReader(bytes.NewReader(frame), "big.json.zst", compressionAuto)
+		var read []byte
+		if err == nil {
+			read, err = ioutil.ReadAll(reader)
+			reader.Close()
+		}
+		if test.fails != (err != nil) || (!test.fails && string(read) != "x") {
+			t.Errorf("%s: read %q with %v, want an error: %v", test.name, read, err, test.fails)
+		}
+	}
+}
+
+func TestValidateCompression(t *testing.T) {
+	for _, compression := range []string{compressionAuto, compressionNone, compressionGzip, compressionSnappy, compressionZstd} {
+		if err := validateCompression(compression); err != nil {
+			t.Errorf("validateCompression(%q): %v", compression, err)
+		}
+	}
+	if err := validateCompression("lz4"); err == nil {
+		t.Errorf("validateCompression(%q) succeeded", "lz4")
+	}
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// The credentials the providers are asked for
+const (
+	credentialClientCert = "client_cert"
+	credentialClientKey  = "client_key"
+	credentialCACert     = "ca_cert"
+	credentialSASLUser   = "sasl_username"
+	credentialSASLPass   = "sasl_password"
+)
+
+// secretCredentials are the credentials that are registered as secrets once found. Certificates and
+// usernames aren't secret, and masking them would hide them from the logs of every connection.
+var secretCredentials = map[string]bool{
+	credentialClientKey: true,
+	credentialSASLPass:  true,
+}
+
+// The names of the providers, as listed in the configured order
+const (
+	providerInline = "inline"
+	providerFile   = "file"
+	providerS3     = "s3"
+	providerHTTP   = "http"
+
+	defaultCredentialProviders   = providerInline + "," + providerFile + "," + providerS3 + "," + providerHTTP
+	credentialProvidersDelimiter = ","
+
+	credentialsHTTPTimeout = 30 * time.Second
+)
+
+// credentialProvider is a source of the Kafka client credentials
+type credentialProvider interface {
+	// lookup returns a credential, or nil when the provider doesn't have it
+	lookup(name string) ([]byte, error)
+	String() string
+}
+
+// inlineCredentialProvider returns the PEM blocks and SASL credentials set in the configuration, such as
+// the environment variables of the Job
+type inlineCredentialProvider struct {
+	values map[string]string
+}
+
+func (p *inlineCredentialProvider) lookup(name string) ([]byte, error) {
+	if value := strings.TrimSpace(p.values[name]); value != "" {
+		return []byte(value), nil
+	}
+	return nil, nil
+}
+
+func (p *inlineCredentialProvider) String() string {
+	return providerInline
+}
+
+// fileCredentialProvider reads the credentials from files, such as mounted secrets. A file that
+// doesn't exist is a missing credential.
+type fileCredentialProvider struct {
+	paths map[string]string
+}
+
+func (p *fileCredentialProvider) lookup(name string) ([]byte, error) {
+	path := p.paths[name]
+	if path == "" {
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s from %s: %v", name, path, err)
+	}
+	return data, nil
+}
+
+func (p *fileCredentialProvider) String() string {
+	return providerFile
+}
+
+// s3CredentialProvider downloads the client certificate and key from the project bucket, following the
+// convention of GetClientCerdentials. It has neither a CA certificate nor SASL credentials.
+type s3CredentialProvider struct {
+	session *session.Session
+	project string
+	site    string
+	depType string
+
+	once       sync.Once
+	clientCert []byte
+	clientKey  []byte
+	err        error
+}
+
+func (p *s3CredentialProvider) lookup(name string) ([]byte, error) {
+	if p.project == "" || (name != credentialClientCert && name != credentialClientKey) {
+		return nil, nil
+	}
+	p.once.Do(func() {
+		p.clientCert, p.clientKey, p.err = GetClientCerdentials(p.session, p.project, p.site, p.depType)
+	})
+	if p.err != nil {
+		return nil, fmt.Errorf("error downloading the client credentials of project %s: %v", p.project, p.err)
+	}
+	if name == credentialClientCert {
+		return p.clientCert, nil
+	}
+	return p.clientKey, nil
+}
+
+func (p *s3CredentialProvider) String() string {
+	return providerS3
+}
+
+// httpCredentialProvider reads the credentials from a secrets endpoint, which returns them as the fields
+// of a JSON object, such as {"client_cert": "...", "client_key": "...", "ca_cert": "...", "sasl_password": "..."}.
+// The endpoint is called once, with the token as a bearer token when it's set.
+type httpCredentialProvider struct {
+	url    string
+	token  string
+	client *http.Client
+
+	once   sync.Once
+	values map[string]string
+	err    error
+}
+
+func newHTTPCredentialProvider(endpoint string, token string) (*httpCredentialProvider, error) {
+	if endpoint != "" {
+		if _, err := url.ParseRequestURI(endpoint); err != nil {
+			return nil, fmt.Errorf("invalid credentials url %q: %v", endpoint, err)
+		}
+	}
+	return &httpCredentialProvider{url: endpoint, token: token, client: &http.Client{Timeout: credentialsHTTPTimeout}}, nil
+}
+
+func (p *httpCredentialProvider) lookup(name string) ([]byte, error) {
+	if p.url == "" {
+		return nil, nil
+	}
+	p.once.Do(func() {
+		p.values, p.err = p.fetch()
+	})
+	if p.err != nil {
+		return nil, p.err
+	}
+	if value := strings.TrimSpace(p.values[name]); value != "" {
+		return []byte(value), nil
+	}
+	return nil, nil
+}
+
+func (p *httpCredentialProvider) fetch() (map[string]string, error) {
+	request, err := http.NewRequest(http.MethodGet, p.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Accept", "application/json")
+	if p.token != "" {
+		request.Header.Set("Authorization", "Bearer "+p.token)
+	}
+	response, err := p.client.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("error calling the credentials endpoint: %v", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("credentials endpoint returned %s", response.Status)
+	}
+
+	values := make(map[string]string)
+	if err := json.NewDecoder(response.Body).Decode(&values); err != nil {
+		return nil, fmt.Errorf("invalid response of the credentials endpoint: %v", err)
+	}
+	return values, nil
+}
+
+func (p *httpCredentialProvider) String() string {
+	return providerHTTP
+}
+
+// credentialChain tries its providers in order, and takes every credential from the first one that has it.
+// The secret credentials found are registered as secrets, so they're never logged.
+type credentialChain []credentialProvider
+
+// newCredentialChain orders the available providers by a comma separated list of their names
+func newCredentialChain(order string, available []credentialProvider) (credentialChain, error) {
+	byName := make(map[string]credentialProvider)
+	for _, provider := range available {
+		byName[provider.String()] = provider
+	}
+
+	var chain credentialChain
+	for _, name := range strings.Split(order, credentialProvidersDelimiter) {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		provider, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown credential provider %q, expected %s, %s, %s or %s", name, providerInline, providerFile, providerS3, providerHTTP)
+		}
+		chain = append(chain, provider)
+	}
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("no credential provider is configured")
+	}
+	return chain, nil
+}
+
+// lookup returns a credential from the first provider that has it, or nil when none has it.
+// A provider that fails is skipped, and its error is returned when no other provider has the credential.
+func (c credentialChain) lookup(name string) ([]byte, error) {
+	var errors []string
+	for _, provider := range c {
+		value, err := provider.lookup(name)
+		if err != nil {
+			logger.warning(componentAuth, fmt.Sprintf("Credential provider %s failed to provide %s: %v", provider, name, err))
+			errors = append(errors, fmt.Sprintf("%s: %v", provider, err))
+			continue
+		}
+		if value != nil {
+			if secretCredentials[name] {
+				secrets.add(string(value))
+			}
+			logger.info(componentAuth, fmt.Sprintf("Using %s of credential provider %s", name, provider))
+			return value, nil
+		}
+	}
+	if len(errors) > 0 {
+		return nil, fmt.Errorf("no credential provider has %s (%s)", name, strings.Join(errors, "; "))
+	}
+	return nil, nil
+}
+
+// clientCertificate returns the client certificate and key of the first provider that has both of them,
+// or nil when none has them
+func (c credentialChain) clientCertificate() ([]byte, []byte, error) {
+	var errors []string
+	for _, provider := range c {
+		cert, err := provider.lookup(credentialClientCert)
+		if err != nil {
+			logger.warning(componentAuth, fmt.Sprintf("Credential provider %s failed to provide the client certificate: %v", provider, err))
+			errors = append(errors, fmt.Sprintf("%s: %v", provider, err))
+			continue
+		}
+		key, err := provider.lookup(credentialClientKey)
+		if err != nil {
+			logger.warning(componentAuth, fmt.Sprintf("Credential provider %s failed to provide the client key: %v", provider, err))
+			errors = append(errors, fmt.Sprintf("%s: %v", provider, err))
+			continue
+		}
+		if cert != nil && key != nil {
+			secrets.add(string(key))
+			logger.info(componentAuth, fmt.Sprintf("Using the client certificate of credential provider %s", provider))
+			return cert, key, nil
+		}
+	}
+	if len(errors) > 0 {
+		return nil, nil, fmt.Errorf("no credential provider has a client certificate and key (%s)", strings.Join(errors, "; "))
+	}
+	return nil, nil, nil
+}
+
+// kafkaTLSCredentials are the key material of the TLS connections to the brokers
+type kafkaTLSCredentials struct {
+	clientCert []byte
+	clientKey  []byte
+	// caCert is nil to trust the system roots
+	caCert []byte
+}
+
+// tlsCredentials resolves the client certificate and the CA certificate. Without requireClientCert, a
+// missing client certificate makes a server-only TLS connection, for brokers that authenticate with SASL.
+func (c credentialChain) tlsCredentials(requireClientCert bool) (kafkaTLSCredentials, error) {
+	var credentials kafkaTLSCredentials
+	var err error
+	if credentials.clientCert, credentials.clientKey, err = c.clientCertificate(); err != nil {
+		return credentials, err
+	}
+	if credentials.clientCert == nil {
+		if requireClientCert {
+			return credentials, fmt.Errorf("no credential provider has a client certificate and key")
+		}
+		logger.info(componentAuth, "No credential provider has a client certificate, the TLS connections are server-only")
+	}
+	if credentials.caCert, err = c.lookup(credentialCACert); err != nil {
+		return credentials, err
+	}
+	if credentials.caCert == nil {
+		logger.info(componentAuth, "No credential provider has a CA certificate, the brokers are verified with the system roots")
+	}
+	return credentials, nil
+}
+
+// saslCredentials resolves the SASL username and password. Usernames aren't secret, and are read as text.
+func (c credentialChain) saslCredentials() (string, string, error) {
+	username, err := c.lookup(credentialSASLUser)
+	if err != nil {
+		return "", "", err
+	}
+	password, err := c.lookup(credentialSASLPass)
+	if err != nil {
+		return "", "", err
+	}
+	if username == nil || password == nil {
+		return "", "", fmt.Errorf("no credential provider has the SASL %s and %s", credentialSASLUser, credentialSASLPass)
+	}
+	return strings.TrimSpace(string(username)), strings.TrimSpace(string(password)), nil
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// staticCredentialProvider has fixed credentials, or fails every lookup with err
+type staticCredentialProvider struct {
+	name   string
+	values map[string]string
+	err    error
+}
+
+func (p *staticCredentialProvider) lookup(name string) ([]byte, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	if value, ok := p.values[name]; ok {
+		return []byte(value), nil
+	}
+	return nil, nil
+}
+
+func (p *staticCredentialProvider) String() string {
+	return p.name
+}
+
+func TestNewCredentialChain(t *testing.T) {
+	var available []credentialProvider
+	for _, name := range []string{providerInline, providerFile, providerS3, providerHTTP} {
+		available = append(available, &staticCredentialProvider{name: name})
+	}
+
+	tests := []struct {
+		order string
+		chain string
+		fails bool
+	}{
+		{order: defaultCredentialProviders, chain: "inline file s3 http"},
+		{order: "http, inline", chain: "http inline"},
+		{order: " s3 ,, file ", chain: "s3 file"},
+		{order: "inline,vault", fails: true},
+		{order: " , ", fails: true},
+	}
+
+	for _, test := range tests {
+		chain, err := newCredentialChain(test.order, available)
+		if test.fails {
+			if err == nil {
+				t.Errorf("newCredentialChain(%q) = %v, want an error", test.order, chain)
+			}
+			continue
+		}
+		var names []string
+		for _, provider := range chain {
+			names = append(names, provider.String())
+		}
+		if err != nil || strings.Join(names, " ") != test.chain {
+			t.Errorf("newCredentialChain(%q) = %v, %v, want %s", test.order, names, err, test.chain)
+		}
+	}
+}
+
+func TestCredentialChainLookup(t *testing.T) {
+	failing := &staticCredentialProvider{name: "failing", err: errors.New("unreachable")}
+	empty := &staticCredentialProvider{name: "empty"}
+	first := &staticCredentialProvider{name: "first", values: map[string]string{credentialCACert: "first-ca", credentialSASLPass: "first-password-value"}}
+	second := &staticCredentialProvider{name: "second", values: map[string]string{credentialCACert: "second-ca", credentialSASLUser: "second-user"}}
+
+	tests := []struct {
+		name  string
+		chain credentialChain
+		key   string
+		value string
+		fails bool
+	}{
+		{name: "first provider", chain: credentialChain{first, second}, key: credentialCACert, value: "first-ca"},
+		{name: "order", chain: credentialChain{second, first}, key: credentialCACert, value: "second-ca"},
+		{name: "fall-through", chain: credentialChain{empty, first, second}, key: credentialSASLUser, value: "second-user"},
+		{name: "fall-through a failed provider", chain: credentialChain{failing, second}, key: credentialCACert, value: "second-ca"},
+		{name: "secret", chain: credentialChain{second, first}, key: credentialSASLPass, value: "first-password-value"},
+		{name: "missing", chain: credentialChain{empty, first}, key: credentialSASLUser, value: ""},
+		{name: "missing with a failed provider", chain: credentialChain{failing, empty}, key: credentialCACert, fails: true},
+	}
+
+	for _, test := range tests {
+		value, err := test.chain.lookup(test.key)
+		if test.fails {
+			if err == nil {
+				t.Errorf("%s: lookup(%s) = %q, want an error", test.name, test.key, value)
+			}
+			continue
+		}
+		if err != nil || string(value) != test.value || (test.value == "") != (value == nil) {
+			t.Errorf("%s: lookup(%s) = %q, %v, want %q", test.name, test.key, value, err, test.value)
+		}
+	}
+
+	// Only the secret credentials are masked once found
+	if redacted := secrets.redact("first-password-value second-user"); redacted != redactedText+" second-user" {
+		t.Errorf("credentials redacted as %q", redacted)
+	}
+}
+
+func TestCredentialChainClientCertificate(t *testing.T) {
+	certOnly := &staticCredentialProvider{name: "cert-only", values: map[string]string{credentialClientCert: "cert-only-cert"}}
+	keyOnly := &staticCredentialProvider{name: "key-only", values: map[string]string{credentialClientKey: "key-only-key-value"}}
+	both := &staticCredentialProvider{name: "both", values: map[string]string{credentialClientCert: "both-cert", credentialClientKey: "both-key-value"}}
+	failing := &staticCredentialProvider{name: "failing", err: errors.New("unreachable")}
+
+	tests := []struct {
+		name  string
+		chain credentialChain
+		cert  string
+		key   string
+		fails bool
+	}{
+		{name: "same provider", chain: credentialChain{certOnly, keyOnly, both}, cert: "both-cert", key: "both-key-value"},
+		{name: "fall-through a failed provider", chain: credentialChain{failing, both}, cert: "both-cert", key: "both-key-value"},
+		{name: "split between providers", chain: credentialChain{certOnly, keyOnly}},
+		{name: "failed provider", chain: credentialChain{certOnly, failing}, fails: true},
+	}
+
+	for _, test := range tests {
+		cert, key, err := test.chain.clientCertificate()
+		if test.fails {
+			if err == nil {
+				t.Errorf("%s: clientCertificate = %q, %q, want an error", test.name, cert, key)
+			}
+			continue
+		}
+		if err != nil || string(cert) != test.cert || string(key) != test.key {
+			t.Errorf("%s: clientCertificate = %q, %q, %v, want %q, %q", test.name, cert, key, err, test.cert, test.key)
+		}
+	}
+
+	if _, err := (credentialChain{certOnly, keyOnly}).tlsCredentials(true); err == nil {
+		t.Errorf("tlsCredentials without a client certificate succeeded, when it's required")
+	}
+	credentials, err := (credentialChain{certOnly, keyOnly}).tlsCredentials(false)
+	if err != nil || credentials.clientCert != nil || credentials.caCert != nil {
+		t.Errorf("server-only tlsCredentials = %+v, %v", credentials, err)
+	}
+}
+
+func TestCredentialChainSASLCredentials(t *testing.T) {
+	users := &staticCredentialProvider{name: "users", values: map[string]string{credentialSASLUser: " restore\n"}}
+	passwords := &staticCredentialProvider{name: "passwords", values: map[string]string{credentialSASLPass: "sasl-password-value\n"}}
+
+	username, password, err := (credentialChain{users, passwords}).saslCredentials()
+	if err != nil || username != "restore" || password != "sasl-password-value" {
+		t.Errorf("saslCredentials = %q, %q, %v", username, password, err)
+	}
+	if _, _, err := (credentialChain{users}).saslCredentials(); err == nil {
+		t.Errorf("saslCredentials without a password succeeded")
+	}
+}
+
+func TestFileCredentialProvider(t *testing.T) {
+	dir, err := ioutil.TempDir("", "credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "ca.pem"), []byte("ca"), 0600)
+
+	provider := &fileCredentialProvider{paths: map[string]string{
+		credentialCACert:     filepath.Join(dir, "ca.pem"),
+		credentialClientCert: filepath.Join(dir, "missing.pem"),
+		credentialClientKey:  dir,
+	}}
+	tests := []struct {
+		name  string
+		value string
+		fails bool
+	}{
+		{name: credentialCACert, value: "ca"},
+		{name: credentialClientCert},
+		{name: credentialSASLPass},
+		{name: credentialClientKey, fails: true},
+	}
+	for _, test := range tests {
+		value, err := provider.lookup(test.name)
+		if test.fails != (err != nil) || string(value) != test.value {
+			t.Errorf("lookup(%s) = %q, %v, want %q", test.name, value, err, test.value)
+		}
+	}
+}
+
+func TestHTTPCredentialProvider(t *testing.T) {
+	tests := []struct {
+		name   string
+		token  string
+		status int
+		body   string
+		value  string
+		fails  bool
+	}{
+		{name: "token", token: "endpoint-token", status: http.StatusOK, body: `{"ca_cert": " ca \n", "client_key": "key"}`, value: "ca"},
+		{name: "no token", status: http.StatusOK, body: `{"ca_cert": "ca"}`, value: "ca"},
+		{name: "missing", status: http.StatusOK, body: `{"client_key": "key"}`},
+		{name: "forbidden", token: "wrong", status: http.StatusForbidden, body: `{"error": "forbidden"}`, fails: true},
+		{name: "server error", status: http.StatusInternalServerError, body: `{}`, fails: true},
+		{name: "bad JSON", status: http.StatusOK, body: `{"ca_cert": `, fails: true},
+		{name: "not an object of strings", status: http.StatusOK, body: `{"ca_cert": 1}`, fails: true},
+	}
+
+	for _, test := range tests {
+		requests := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			wantAuthorization := ""
+			if test.token != "" {
+				wantAuthorization = "Bearer " + test.token
+			}
+			if r.Header.Get("Authorization") != wantAuthorization {
+				t.Errorf("%s: authorization is %q, want %q", test.name, r.Header.Get("Authorization"), wantAuthorization)
+			}
+			w.WriteHeader(test.status)
+			w.Write([]byte(test.body))
+		}))
+
+		provider, err := newHTTPCredentialProvider(server.URL+"/kafka", test.token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		value, err := provider.lookup(credentialCACert)
+		provider.lookup(credentialClientCert)
+		server.Close()
+
+		if test.fails {
+			if err == nil {
+				t.Errorf("%s: lookup = %q, want an error", test.name, value)
+			}
+		} else if err != nil || string(value) != test.value || (test.value == "") != (value == nil) {
+			t.Errorf("%s: lookup = %q, %v, want %q", test.name, value, err, test.value)
+		}
+		if requests != 1 {
+			t.Errorf("%s: the endpoint was called %d times, want once", test.name, requests)
+		}
+	}
+
+	if _, err := newHTTPCredentialProvider("not a url", ""); err == nil {
+		t.Errorf("newHTTPCredentialProvider with an invalid url succeeded")
+	}
+	provider, err := newHTTPCredentialProvider("", "")
+	if value, lookupErr := provider.lookup(credentialCACert); err != nil || value != nil || lookupErr != nil {
+		t.Errorf("provider without a url = %q, %v, %v", value, err, lookupErr)
+	}
+}
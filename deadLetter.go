@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Shopify/sarama"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+const (
+	deadLetterKafkaScheme = "kafka://"
+
+	// deadLetterObjectBytes is the size from which the S3 sink uploads the entries it holds as an object
+	deadLetterObjectBytes = 5 * 1024 * 1024
+)
+
+// deadLetter is a record that failed to produce, with where it was read from and why it failed.
+// The key, value, headers and timestamp follow the envelope record format, so the entries of a dead-letter
+// file can be restored again with record_format=envelope. Keys and values that aren't UTF-8 text, such as
+// Avro ones, are written as base64, which the encoding tells. The value of a tombstone is null.
+type deadLetter struct {
+	Key         string        `json:"key"`
+	Value       *string       `json:"value"`
+	Encoding    string        `json:"encoding,omitempty"`
+	Headers     []KafkaHeader `json:"headers,omitempty"`
+	Timestamp   *time.Time    `json:"timestamp,omitempty"`
+	SourceTopic string        `json:"source_topic"`
+	TargetTopic string        `json:"target_topic"`
+	Partition   int32         `json:"partition"`
+	Offset      int64         `json:"offset"`
+	ObjectKey   string        `json:"object_key"`
+	Line        int64         `json:"line"`
+	Error       string        `json:"error"`
+	FailedAt    time.Time     `json:"failed_at"`
+
+	// record is acknowledged in the checkpoint once the entry is stored
+	record *restoreRecord
+}
+
+// newDeadLetter returns the dead-letter entry of a record that failed to produce into targetTopic
+func newDeadLetter(record *restoreRecord, targetTopic string, err error) deadLetter {
+	entry := deadLetter{
+		Key:         record.Key,
+		Headers:     record.Headers,
+		SourceTopic: record.Topic,
+		TargetTopic: targetTopic,
+		Partition:   record.Partition,
+		Offset:      record.Offset,
+		ObjectKey:   record.ObjectKey,
+		Line:        record.Line,
+		Error:       err.Error(),
+		FailedAt:    time.Now().UTC(),
+		record:      record,
+	}
+	value := record.Value
+	if !utf8.ValidString(record.Key) || !utf8.ValidString(record.Value) {
+		entry.Key = base64.StdEncoding.EncodeToString([]byte(record.Key))
+		value = base64.StdEncoding.EncodeToString([]byte(record.Value))
+		entry.Encoding = envelopeEncodingBase64
+	}
+	if !record.tombstone {
+		entry.Value = &value
+	}
+	if !record.Timestamp.IsZero() {
+		timestamp := record.Timestamp
+		entry.Timestamp = &timestamp
+	}
+	return entry
+}
+
+// deadLetterSink keeps the records that failed to produce. It's only used by ProcessResponse.
+type deadLetterSink interface {
+	// write returns the entries that are stored for good, which may be held ones written before
+	write(entry deadLetter) ([]deadLetter, error)
+	// flush stores the entries still held, and returns them
+	flush() ([]deadLetter, error)
+	Close() error
+	String() string
+}
+
+// newDeadLetterSink returns the sink of a dead-letter location: s3://bucket/prefix, kafka://topic, or else
+// the path of a local JSONL file
+func newDeadLetterSink(s3Client *s3.S3, location string, brokers []string, config *sarama.Config) (deadLetterSink, error) {
+	switch {
+	case strings.HasPrefix(location, s3LocationScheme):
+		parts := strings.SplitN(strings.TrimPrefix(location, s3LocationScheme), "/", 2)
+		if parts[0] == "" {
+			return nil, fmt.Errorf("dead-letter location %q must look like s3://bucket/prefix", location)
+		}
+		prefix := ""
+		if len(parts) == 2 && strings.Trim(parts[1], "/") != "" {
+			prefix = strings.Trim(parts[1], "/") + "/"
+		}
+		return &s3DeadLetterSink{client: s3Client, bucket: parts[0], prefix: prefix, run: time.Now().UTC().Format("20060102T150405Z")}, nil
+
+	case strings.HasPrefix(location, deadLetterKafkaScheme):
+		topic := strings.TrimPrefix(location, deadLetterKafkaScheme)
+		if err := validateTopicName(topic); err != nil {
+			return nil, fmt.Errorf("invalid dead-letter topic: %v", err)
+		}
+		// The dead letters are spread by their key, whatever the partitioner of the restore
+		dlqConfig := *config
+		dlqConfig.Producer.Partitioner = sarama.NewHashPartitioner
+		producer, err := sarama.NewSyncProducer(brokers, &dlqConfig)
+		if err != nil {
+			return nil, err
+		}
+		return &topicDeadLetterSink{producer: producer, topic: topic}, nil
+	}
+
+	if dir := filepath.Dir(location); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+	file, err := os.OpenFile(location, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &fileDeadLetterSink{file: file}, nil
+}
+
+// fileDeadLetterSink appends the entries to a local JSONL file
+type fileDeadLetterSink struct {
+	file *os.File
+}
+
+func (s *fileDeadLetterSink) write(entry deadLetter) ([]deadLetter, error) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.file.Write(append(data, '\n')); err != nil {
+		return nil, err
+	}
+	return []deadLetter{entry}, nil
+}
+
+func (s *fileDeadLetterSink) flush() ([]deadLetter, error) {
+	return nil, nil
+}
+
+func (s *fileDeadLetterSink) Close() error {
+	return s.file.Close()
+}
+
+func (s *fileDeadLetterSink) String() string {
+	return s.file.Name()
+}
+
+// s3DeadLetterSink uploads the entries as JSONL objects under a prefix. The entries are held until they
+// reach deadLetterObjectBytes or the sink is flushed, since S3 objects can't be appended to, and are only
+// returned as stored once their part is uploaded. Entries written after Close are refused, as they would
+// never be uploaded.
+type s3DeadLetterSink struct {
+	mutex  sync.Mutex
+	closed bool
+	client *s3.S3
+	bucket string
+	prefix string
+	// run names the objects of this restore, which are numbered by parts
+	run    string
+	part   int
+	buffer bytes.Buffer
+	// held are the entries of the buffer
+	held []deadLetter
+}
+
+func (s *s3DeadLetterSink) write(entry deadLetter) ([]deadLetter, error) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return nil, fmt.Errorf("dead-letter sink %s is closed", s)
+	}
+	s.buffer.Write(append(data, '\n'))
+	s.held = append(s.held, entry)
+	if s.buffer.Len() >= deadLetterObjectBytes {
+		return s.upload()
+	}
+	return nil, nil
+}
+
+func (s *s3DeadLetterSink) flush() ([]deadLetter, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.upload()
+}
+
+// upload uploads the entries held so far as the next part, and returns them. On failure they're kept
+// for the next upload. Must be called with the mutex held.
+func (s *s3DeadLetterSink) upload() ([]deadLetter, error) {
+	if s.buffer.Len() == 0 {
+		return nil, nil
+	}
+	s.part++
+	key := fmt.Sprintf("%sdead-letters-%s-%04d.jsonl", s.prefix, s.run, s.part)
+	_, err := s.client.PutObject(&s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(s.buffer.Bytes()),
+		ContentType: aws.String("application/x-ndjson"),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error uploading %d dead letters to s3://%s/%s: %v", len(s.held), s.bucket, key, err)
+	}
+	stored := s.held
+	s.held = nil
+	s.buffer.Reset()
+	return stored, nil
+}
+
+// Close refuses the next entries. The entries still held are lost, so the sink is flushed first.
+func (s *s3DeadLetterSink) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.closed = true
+	if len(s.held) > 0 {
+		return fmt.Errorf("dead-letter sink %s closed with %d entries that weren't uploaded", s, len(s.held))
+	}
+	return nil
+}
+
+func (s *s3DeadLetterSink) String() string {
+	return s3LocationScheme + s.bucket + "/" + s.prefix
+}
+
+// topicDeadLetterSink produces the entries as JSON messages into a dead-letter topic, keyed like the records
+type topicDeadLetterSink struct {
+	producer sarama.SyncProducer
+	topic    string
+}
+
+func (s *topicDeadLetterSink) write(entry deadLetter) ([]deadLetter, error) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+	message := &sarama.ProducerMessage{Topic: s.topic, Value: sarama.ByteEncoder(data)}
+	// The original key, since the one of the entry may be base64
+	if entry.record.Key != "" {
+		message.Key = sarama.StringEncoder(entry.record.Key)
+	}
+	if _, _, err := s.producer.SendMessage(message); err != nil {
+		return nil, err
+	}
+	return []deadLetter{entry}, nil
+}
+
+func (s *topicDeadLetterSink) flush() ([]deadLetter, error) {
+	return nil, nil
+}
+
+func (s *topicDeadLetterSink) Close() error {
+	return s.producer.Close()
+}
+
+func (s *topicDeadLetterSink) String() string {
+	return deadLetterKafkaScheme + s.topic
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Shopify/sarama"
+)
+
+func TestNewDeadLetter(t *testing.T) {
+	tests := []struct {
+		name   string
+		record *restoreRecord
+		want   string
+	}{
+		{
+			name:   "text",
+			record: &restoreRecord{KafkaMessage: KafkaMessage{Key: "k1", Value: `{"id":1}`}},
+			want:   `"key":"k1","value":"{\"id\":1}",`,
+		},
+		{
+			name:   "binary",
+			record: &restoreRecord{KafkaMessage: KafkaMessage{Key: "k1", Value: "\x00\xff"}},
+			want:   `"key":"azE=","value":"AP8=","encoding":"base64",`,
+		},
+		{
+			name:   "tombstone",
+			record: &restoreRecord{KafkaMessage: KafkaMessage{Key: "k1"}, tombstone: true},
+			want:   `"key":"k1","value":null,`,
+		},
+		{
+			name:   "binary tombstone",
+			record: &restoreRecord{KafkaMessage: KafkaMessage{Key: "\xff"}, tombstone: true},
+			want:   `"key":"/w==","value":null,"encoding":"base64",`,
+		},
+	}
+
+	for _, test := range tests {
+		data, err := json.Marshal(newDeadLetter(test.record, "orders", errors.New("failed")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data[1:len(test.want)+1]) != test.want {
+			t.Errorf("%s: dead letter is %s, want it to start with %s", test.name, data, test.want)
+		}
+
+		// The entry restores the same record with record_format=envelope
+		decoder, err := newRecordDecoder(recordFormatEnvelope, envelopeFields{key: "key", value: "value", headers: "headers", timestamp: "timestamp", encoding: "encoding"}, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		record := &restoreRecord{}
+		if err := decoder.decode(data, nil, nil, record); err != nil {
+			t.Errorf("%s: decode(%s): %v", test.name, data, err)
+		} else if record.Key != test.record.Key || record.Value != test.record.Value || record.tombstone != test.record.tombstone {
+			t.Errorf("%s: restored %q, %q, tombstone %v from %s", test.name, record.Key, record.Value, record.tombstone, data)
+		}
+	}
+}
+
+func TestNewDeadLetterSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dead-letters")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).SetBroker(broker.Addr(), broker.BrokerID()),
+	})
+	config := sarama.NewConfig()
+	config.Producer.Return.Successes = true
+
+	tests := []struct {
+		location string
+		sink     string
+		fails    bool
+	}{
+		{location: "s3://backups/dead-letters/orders/", sink: "s3://backups/dead-letters/orders/"},
+		{location: "s3://backups", sink: "s3://backups/"},
+		{location: "s3://backups//", sink: "s3://backups/"},
+		{location: "s3:///dead-letters", fails: true},
+		{location: "kafka://orders-dlq", sink: "kafka://orders-dlq"},
+		{location: "kafka://orders dlq", fails: true},
+		{location: "kafka://", fails: true},
+		{location: filepath.Join(dir, "restore", "dead-letters.jsonl"), sink: filepath.Join(dir, "restore", "dead-letters.jsonl")},
+		{location: filepath.Join(dir, "missing.jsonl", "file"), fails: true},
+	}
+	// A file where a directory is expected
+	ioutil.WriteFile(filepath.Join(dir, "missing.jsonl"), nil, 0644)
+
+	for _, test := range tests {
+		sink, err := newDeadLetterSink(nil, test.location, []string{broker.Addr()}, config)
+		if test.fails {
+			if err == nil {
+				t.Errorf("newDeadLetterSink(%q) = %s, want an error", test.location, sink)
+				sink.Close()
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("newDeadLetterSink(%q): %v", test.location, err)
+			continue
+		}
+		if sink.String() != test.sink {
+			t.Errorf("newDeadLetterSink(%q) = %s, want %s", test.location, sink, test.sink)
+		}
+		sink.Close()
+	}
+}
+
+func TestFileDeadLetterSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dead-letters")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	location := filepath.Join(dir, "dead-letters.jsonl")
+
+	sink, err := newDeadLetterSink(nil, location, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := &restoreRecord{KafkaMessage: KafkaMessage{Key: "k", Value: "v", Topic: "orders"}, ObjectKey: "a.json", Line: 3}
+	stored, err := sink.write(newDeadLetter(record, "orders-restore", errors.New("message too large")))
+	if err != nil || len(stored) != 1 || stored[0].record != record {
+		t.Fatalf("write stored %v, %v, want the entry right away", stored, err)
+	}
+	sink.Close()
+
+	data, err := ioutil.ReadFile(location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entry deadLetter
+	if err := json.Unmarshal(data, &entry); err != nil || entry.Key != "k" || entry.Value == nil || *entry.Value != "v" || entry.Line != 3 || entry.Error != "message too large" {
+		t.Errorf("dead-letter file holds %s, %v", data, err)
+	}
+}
+
+func TestS3DeadLetterSink(t *testing.T) {
+	fake := newFakeS3(nil)
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	sink, err := newDeadLetterSink(newTestS3Client(server), "s3://"+testBucket+"/dead-letters", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []deadLetter
+	for line := int64(1); line <= 3; line++ {
+		record := &restoreRecord{KafkaMessage: KafkaMessage{Value: "v"}, ObjectKey: "a.json", Line: line}
+		entries = append(entries, newDeadLetter(record, "orders", errors.New("failed")))
+	}
+
+	// The entries are held, and only stored once they're uploaded
+	for _, entry := range entries[:2] {
+		if stored, err := sink.write(entry); err != nil || len(stored) != 0 {
+			t.Errorf("write of line %d stored %d entries, %v, want them held", entry.Line, len(stored), err)
+		}
+	}
+	fake.failPuts = true
+	if stored, err := sink.flush(); err == nil || len(stored) != 0 || len(fake.puts) != 0 {
+		t.Errorf("failed upload stored %d entries, %v", len(stored), err)
+	}
+	if err := sink.Close(); err == nil {
+		t.Errorf("sink closed with held entries without an error")
+	}
+
+	// The held entries are uploaded by the next flush, even once the sink refuses new ones
+	fake.failPuts = false
+	if _, err := sink.write(entries[2]); err == nil {
+		t.Errorf("closed sink took an entry")
+	}
+	stored, err := sink.flush()
+	if err != nil || len(stored) != 2 || stored[0].Line != 1 || stored[1].Line != 2 {
+		t.Fatalf("flush stored %v, %v, want lines 1 and 2", stored, err)
+	}
+	if len(fake.puts) != 1 {
+		t.Fatalf("uploaded %d objects, want 1", len(fake.puts))
+	}
+	for key, body := range fake.puts {
+		if !strings.HasPrefix(key, "dead-letters/dead-letters-") || !strings.HasSuffix(key, ".jsonl") || strings.Count(body, "\n") != 2 {
+			t.Errorf("uploaded %s with %q", key, body)
+		}
+	}
+	if stored, err := sink.flush(); err != nil || len(stored) != 0 {
+		t.Errorf("flush of an empty sink stored %d entries, %v", len(stored), err)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"text/tabwriter"
+)
+
+// partitionDelivery counts the acknowledgements and failures of one partition of a target topic
+type partitionDelivery struct {
+	Acked  int64
+	Failed int64
+}
+
+// topicDelivery counts the messages produced into a target topic. The partition of a message is only known
+// once the producer assigned it, so the sent messages are counted by topic.
+type topicDelivery struct {
+	Sent   int64
+	Acked  int64
+	Failed int64
+	// DeadLettered counts the failed messages kept by the dead-letter sink
+	DeadLettered int64
+	partitions   map[int32]*partitionDelivery
+}
+
+// inFlight is the number of messages sent without an acknowledgement or a failure yet
+func (t topicDelivery) inFlight() int64 {
+	return t.Sent - t.Acked - t.Failed
+}
+
+// deliveryStats counts the messages sent, acknowledged and failed by target topic and partition.
+// It's shared by the produce loop and ProcessResponse.
+type deliveryStats struct {
+	mutex  sync.Mutex
+	topics map[string]*topicDelivery
+}
+
+func newDeliveryStats() *deliveryStats {
+	return &deliveryStats{topics: make(map[string]*topicDelivery)}
+}
+
+// topic returns the counters of a topic, creating them on first use. The mutex must be held.
+func (s *deliveryStats) topic(topic string) *topicDelivery {
+	delivery, ok := s.topics[topic]
+	if !ok {
+		delivery = &topicDelivery{partitions: make(map[int32]*partitionDelivery)}
+		s.topics[topic] = delivery
+	}
+	return delivery
+}
+
+// partition returns the counters of a partition, creating them on first use. The mutex must be held.
+func (s *deliveryStats) partition(topic string, partition int32) *partitionDelivery {
+	delivery := s.topic(topic)
+	counts, ok := delivery.partitions[partition]
+	if !ok {
+		counts = &partitionDelivery{}
+		delivery.partitions[partition] = counts
+	}
+	return counts
+}
+
+// sent counts a message handed to the producer
+func (s *deliveryStats) sent(topic string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.topic(topic).Sent++
+	recordsSent.WithLabelValues(topic).Inc()
+}
+
+// acked counts a message the brokers acknowledged
+func (s *deliveryStats) acked(topic string, partition int32) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.topic(topic).Acked++
+	s.partition(topic, partition).Acked++
+	recordsAcked.WithLabelValues(topic, partitionLabel(partition)).Inc()
+}
+
+// failed counts a message the producer gave up on
+func (s *deliveryStats) failed(topic string, partition int32) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.topic(topic).Failed++
+	s.partition(topic, partition).Failed++
+	recordsFailed.WithLabelValues(topic, partitionLabel(partition)).Inc()
+}
+
+// deadLettered counts a failed message written into the dead-letter sink
+func (s *deliveryStats) deadLettered(topic string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.topic(topic).DeadLettered++
+	recordsDeadLettered.WithLabelValues(topic).Inc()
+}
+
+// totals returns the counters of all the topics together
+func (s *deliveryStats) totals() topicDelivery {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var totals topicDelivery
+	for _, delivery := range s.topics {
+		totals.Sent += delivery.Sent
+		totals.Acked += delivery.Acked
+		totals.Failed += delivery.Failed
+		totals.DeadLettered += delivery.DeadLettered
+	}
+	return totals
+}
+
+// complete reports whether every message sent was acknowledged
+func (s *deliveryStats) complete() bool {
+	totals := s.totals()
+	return totals.Failed == 0 && totals.inFlight() == 0
+}
+
+// writeSummary prints the counters of every topic and partition
+func (s *deliveryStats) writeSummary(output io.Writer) error {
+	s.mutex.Lock()
+	topics := make([]string, 0, len(s.topics))
+	for topic := range s.topics {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+
+	writer := tabwriter.NewWriter(output, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(writer, "Topic\tPartition\tSent\tAcked\tFailed\tDead-lettered\tIn flight\n")
+	for _, topic := range topics {
+		delivery := s.topics[topic]
+		fmt.Fprintf(writer, "%s\t*\t%d\t%d\t%d\t%d\t%d\n", topic, delivery.Sent, delivery.Acked, delivery.Failed, delivery.DeadLettered, delivery.inFlight())
+
+		partitions := make([]int32, 0, len(delivery.partitions))
+		for partition := range delivery.partitions {
+			partitions = append(partitions, partition)
+		}
+		sort.Slice(partitions, func(i, j int) bool { return partitions[i] < partitions[j] })
+		for _, partition := range partitions {
+			counts := delivery.partitions[partition]
+			fmt.Fprintf(writer, "%s\t%d\t\t%d\t%d\t\t\n", topic, partition, counts.Acked, counts.Failed)
+		}
+	}
+	s.mutex.Unlock()
+
+	totals := s.totals()
+	fmt.Fprintf(writer, "Total\t\t%d\t%d\t%d\t%d\t%d\n", totals.Sent, totals.Acked, totals.Failed, totals.DeadLettered, totals.inFlight())
+	return writer.Flush()
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// deliveryEvent is a message sent into topic, then acked, failed or dead-lettered on a partition
+type deliveryEvent struct {
+	topic     string
+	partition int32
+	outcome   string
+}
+
+// countDeliveries counts the events into stats
+func countDeliveries(stats *deliveryStats, events []deliveryEvent) {
+	for _, event := range events {
+		stats.sent(event.topic)
+		switch event.outcome {
+		case "acked":
+			stats.acked(event.topic, event.partition)
+		case "failed":
+			stats.failed(event.topic, event.partition)
+		case "dead-lettered":
+			stats.failed(event.topic, event.partition)
+			stats.deadLettered(event.topic)
+		}
+	}
+}
+
+func TestDeliveryStats(t *testing.T) {
+	tests := []struct {
+		name     string
+		events   []deliveryEvent
+		totals   topicDelivery
+		complete bool
+	}{
+		{name: "nothing sent", complete: true},
+		{
+			name:     "all acked",
+			events:   []deliveryEvent{{"orders", 0, "acked"}, {"orders", 1, "acked"}, {"payments", 0, "acked"}},
+			totals:   topicDelivery{Sent: 3, Acked: 3},
+			complete: true,
+		},
+		{
+			name:   "failed",
+			events: []deliveryEvent{{"orders", 0, "acked"}, {"orders", 1, "failed"}},
+			totals: topicDelivery{Sent: 2, Acked: 1, Failed: 1},
+		},
+		{
+			name:   "dead-lettered",
+			events: []deliveryEvent{{"orders", 0, "acked"}, {"orders", 1, "dead-lettered"}},
+			totals: topicDelivery{Sent: 2, Acked: 1, Failed: 1, DeadLettered: 1},
+		},
+		{
+			name:   "in flight",
+			events: []deliveryEvent{{"orders", 0, "acked"}, {"orders", 0, ""}},
+			totals: topicDelivery{Sent: 2, Acked: 1},
+		},
+		{
+			name:   "failed, dead-lettered and in flight",
+			events: []deliveryEvent{{"orders", 0, "failed"}, {"payments", 2, "dead-lettered"}, {"payments", 2, "acked"}, {"users", 0, ""}},
+			totals: topicDelivery{Sent: 4, Acked: 1, Failed: 2, DeadLettered: 1},
+		},
+	}
+
+	for _, test := range tests {
+		stats := newDeliveryStats()
+		countDeliveries(stats, test.events)
+
+		totals := stats.totals()
+		if totals.Sent != test.totals.Sent || totals.Acked != test.totals.Acked || totals.Failed != test.totals.Failed || totals.DeadLettered != test.totals.DeadLettered {
+			t.Errorf("%s: totals are %+v, want %+v", test.name, totals, test.totals)
+		}
+		if complete := stats.complete(); complete != test.complete {
+			t.Errorf("%s: complete is %v, want %v", test.name, complete, test.complete)
+		}
+	}
+}
+
+func TestDeliveryStatsSummary(t *testing.T) {
+	stats := newDeliveryStats()
+	countDeliveries(stats, []deliveryEvent{
+		{"payments", 1, "acked"},
+		{"orders", 2, "acked"},
+		{"orders", 0, "acked"},
+		{"orders", 2, "failed"},
+		{"orders", 2, "dead-lettered"},
+		{"orders", 0, ""},
+	})
+
+	var summary strings.Builder
+	if err := stats.writeSummary(&summary); err != nil {
+		t.Fatal(err)
+	}
+	var rows []string
+	for _, line := range strings.Split(strings.TrimSuffix(summary.String(), "\n"), "\n") {
+		rows = append(rows, strings.Join(strings.Fields(line), " "))
+	}
+	want := []string{
+		"Topic Partition Sent Acked Failed Dead-lettered In flight",
+		"orders * 5 2 2 1 1",
+		"orders 0 1 0",
+		"orders 2 1 2",
+		"payments * 1 1 0 0 0",
+		"payments 1 1 0",
+		"Total 6 3 2 1 1",
+	}
+	if strings.Join(rows, "\n") != strings.Join(want, "\n") {
+		t.Errorf("summary is\n%s\nwant\n%s", strings.Join(rows, "\n"), strings.Join(want, "\n"))
+	}
+}
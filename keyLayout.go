@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	layoutVarTopicsDir = "{topics_dir}"
+	layoutVarTopic     = "{topic}"
+	layoutVarYear      = "{year}"
+	layoutVarMonth     = "{month}"
+	layoutVarDay       = "{day}"
+	layoutVarHour      = "{hour}"
+	layoutVarPartition = "{partition}"
+
+	// defaultKeyLayout matches the Kafka Connect TimeBasedPartitioner with a daily path.format
+	defaultKeyLayout = "{topics_dir}/{topic}/year={year}/month={month}/day={day}"
+	defaultTopicsDir = "topics"
+)
+
+// layoutGranularity is the smallest time unit that appears in a key layout
+type layoutGranularity int
+
+const (
+	granularityNone layoutGranularity = iota
+	granularityYear
+	granularityMonth
+	granularityDay
+	granularityHour
+)
+
+var layoutVarPattern = regexp.MustCompile(`\{[a-z_]+\}`)
+
+// connectObjectNamePattern matches the <topic>+<partition>+<startOffset> names written by the Kafka Connect S3 sink
+var connectObjectNamePattern = regexp.MustCompile(`^(.+)\+([0-9]+)\+([0-9]+)(\..*)?$`)
+
+// keyLayout describes where the backup objects of a topic are stored inside the bucket.
+// The template may use {topics_dir}, {topic}, {year}, {month}, {day}, {hour} and {partition}.
+type keyLayout struct {
+	template    string
+	topicsDir   string
+	granularity layoutGranularity
+}
+
+// newKeyLayout validates a layout template and returns the matching keyLayout
+func newKeyLayout(template string, topicsDir string) (*keyLayout, error) {
+	if template == "" {
+		template = defaultKeyLayout
+	}
+	if topicsDir == "" {
+		topicsDir = defaultTopicsDir
+	}
+	template = strings.TrimPrefix(template, "/")
+
+	for _, variable := range layoutVarPattern.FindAllString(template, -1) {
+		switch variable {
+		case layoutVarTopicsDir, layoutVarTopic, layoutVarYear, layoutVarMonth, layoutVarDay, layoutVarHour, layoutVarPartition:
+		default:
+			return nil, fmt.Errorf("unknown variable %s in key layout %q", variable, template)
+		}
+	}
+	if !strings.Contains(template, layoutVarTopic) {
+		return nil, fmt.Errorf("key layout %q must contain %s", template, layoutVarTopic)
+	}
+
+	layout := &keyLayout{template: template, topicsDir: strings.Trim(topicsDir, "/")}
+	switch {
+	case strings.Contains(template, layoutVarHour):
+		layout.granularity = granularityHour
+	case strings.Contains(template, layoutVarDay):
+		layout.granularity = granularityDay
+	case strings.Contains(template, layoutVarMonth):
+		layout.granularity = granularityMonth
+	case strings.Contains(template, layoutVarYear):
+		layout.granularity = granularityYear
+	}
+	return layout, nil
+}
+
+// truncate returns the start of the layout period that contains t
+func (l *keyLayout) truncate(t time.Time) time.Time {
+	t = t.UTC()
+	switch l.granularity {
+	case granularityHour:
+		return t.Truncate(time.Hour)
+	case granularityDay:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	case granularityMonth:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	case granularityYear:
+		return time.Date(t.Year(), 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	return t
+}
+
+// next returns the start of the layout period that follows the one starting at t
+func (l *keyLayout) next(t time.Time) time.Time {
+	switch l.granularity {
+	case granularityHour:
+		return t.Add(time.Hour)
+	case granularityDay:
+		return t.AddDate(0, 0, 1)
+	case granularityMonth:
+		return t.AddDate(0, 1, 0)
+	case granularityYear:
+		return t.AddDate(1, 0, 0)
+	}
+	return t
+}
+
+// periods returns the start of every layout period between start and the exclusive end.
+// A layout without date variables has a single period.
+func (l *keyLayout) periods(start time.Time, end time.Time) []time.Time {
+	first := l.truncate(start)
+	if l.granularity == granularityNone {
+		return []time.Time{first}
+	}
+
+	var periods []time.Time
+	for period := first; period.Before(end); period = l.next(period) {
+		periods = append(periods, period)
+	}
+	return periods
+}
+
+// expand replaces the topic and date variables of the template. {partition} is left in place.
+func (l *keyLayout) expand(topic string, period time.Time) string {
+	period = period.UTC()
+	return strings.NewReplacer(
+		layoutVarTopicsDir, l.topicsDir,
+		layoutVarTopic, topic,
+		layoutVarYear, period.Format("2006"),
+		layoutVarMonth, period.Format("01"),
+		layoutVarDay, period.Format("02"),
+		layoutVarHour, period.Format("15"),
+	).Replace(l.template)
+}
+
+// directory returns the expanded template of a period, which is a directory holding the objects. It ends with
+// a slash, so the keys of topics named like the topic, such as orders-v2 for orders, are left out.
+func (l *keyLayout) directory(topic string, period time.Time) string {
+	expanded := l.expand(topic, period)
+	if !strings.HasSuffix(expanded, "/") {
+		expanded += "/"
+	}
+	return expanded
+}
+
+// prefix returns the listing prefix of a period, cut before the first {partition} variable
+func (l *keyLayout) prefix(topic string, period time.Time) string {
+	expanded := l.directory(topic, period)
+	if index := strings.Index(expanded, layoutVarPartition); index >= 0 {
+		return expanded[:index]
+	}
+	return expanded
+}
+
+// matcher returns a pattern for the keys of a period, or nil when the listing prefix is enough
+func (l *keyLayout) matcher(topic string, period time.Time) *regexp.Regexp {
+	expanded := l.directory(topic, period)
+	if !strings.Contains(expanded, layoutVarPartition) {
+		return nil
+	}
+
+	parts := strings.Split(expanded, layoutVarPartition)
+	for i := range parts {
+		parts[i] = regexp.QuoteMeta(parts[i])
+	}
+	return regexp.MustCompile("^" + strings.Join(parts, "[0-9]+"))
+}
+
+// topicsPrefix returns the prefix under which every topic has its own directory, such as topics/.
+// It fails when the layout doesn't keep each topic in a directory of its own.
+func (l *keyLayout) topicsPrefix() (string, error) {
+	index := strings.Index(l.template, layoutVarTopic)
+	prefix := strings.Replace(l.template[:index], layoutVarTopicsDir, l.topicsDir, -1)
+	if layoutVarPattern.MatchString(prefix) || !strings.HasPrefix(l.template[index+len(layoutVarTopic):], "/") {
+		return "", fmt.Errorf("can't discover topics with key layout %q, the topic must be a directory under fixed prefix", l.template)
+	}
+	return prefix, nil
+}
+
+// parseConnectObjectName returns the topic, partition and start offset encoded in the name of a Kafka Connect object
+func parseConnectObjectName(key string) (topic string, partition int32, startOffset int64, err error) {
+	name := path.Base(key)
+	match := connectObjectNamePattern.FindStringSubmatch(name)
+	if match == nil {
+		return "", 0, 0, fmt.Errorf("object name %q doesn't look like <topic>+<partition>+<startOffset>", name)
+	}
+
+	parsedPartition, err := strconv.ParseInt(match[2], 10, 32)
+	if err != nil {
+		return "", 0, 0, fmt.Errorf("invalid partition in object name %q: %v", name, err)
+	}
+	startOffset, err = strconv.ParseInt(match[3], 10, 64)
+	if err != nil {
+		return "", 0, 0, fmt.Errorf("invalid start offset in object name %q: %v", name, err)
+	}
+	return match[1], int32(parsedPartition), startOffset, nil
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestKeyLayoutPeriods(t *testing.T) {
+	start := time.Date(2020, 4, 27, 22, 30, 0, 0, time.UTC)
+	tests := []struct {
+		template string
+		end      time.Time
+		periods  []time.Time
+	}{
+		{
+			template: "{topics_dir}/{topic}/{year}/{month}/{day}/{hour}",
+			end:      time.Date(2020, 4, 28, 1, 0, 0, 0, time.UTC),
+			periods: []time.Time{
+				time.Date(2020, 4, 27, 22, 0, 0, 0, time.UTC),
+				time.Date(2020, 4, 27, 23, 0, 0, 0, time.UTC),
+				time.Date(2020, 4, 28, 0, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			template: defaultKeyLayout,
+			end:      time.Date(2020, 4, 29, 0, 0, 1, 0, time.UTC),
+			periods: []time.Time{
+				time.Date(2020, 4, 27, 0, 0, 0, 0, time.UTC),
+				time.Date(2020, 4, 28, 0, 0, 0, 0, time.UTC),
+				time.Date(2020, 4, 29, 0, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			template: "{topic}/year={year}/month={month}",
+			end:      time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC),
+			periods: []time.Time{
+				time.Date(2020, 4, 1, 0, 0, 0, 0, time.UTC),
+				time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			template: "{topics_dir}/{topic}/{partition}",
+			end:      time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+			periods:  []time.Time{start},
+		},
+	}
+
+	for _, test := range tests {
+		layout, err := newKeyLayout(test.template, "")
+		if err != nil {
+			t.Fatalf("newKeyLayout(%q): %v", test.template, err)
+		}
+		periods := layout.periods(start, test.end)
+		if len(periods) != len(test.periods) {
+			t.Errorf("%q: got %d periods %v, want %v", test.template, len(periods), periods, test.periods)
+			continue
+		}
+		for i := range periods {
+			if !periods[i].Equal(test.periods[i]) {
+				t.Errorf("%q: period %d is %v, want %v", test.template, i, periods[i], test.periods[i])
+			}
+		}
+	}
+}
+
+func TestNewKeyLayoutErrors(t *testing.T) {
+	for _, template := range []string{
+		"{topics_dir}/{year}/{month}",
+		"{topics_dir}/{topic}/{minute}",
+	} {
+		if _, err := newKeyLayout(template, ""); err == nil {
+			t.Errorf("newKeyLayout(%q) succeeded, want an error", template)
+		}
+	}
+}
+
+func TestKeyLayoutPrefixAndMatcher(t *testing.T) {
+	period := time.Date(2020, 4, 27, 13, 0, 0, 0, time.UTC)
+	tests := []struct {
+		template string
+		prefix   string
+		// matcher is empty when the prefix is enough
+		matcher string
+		matches []string
+		misses  []string
+	}{
+		{
+			template: defaultKeyLayout,
+			prefix:   "topics/orders/year=2020/month=04/day=27/",
+		},
+		{
+			template: "{topics_dir}/{topic}",
+			prefix:   "topics/orders/",
+		},
+		{
+			template: "backup/{topic}/partition={partition}/{year}-{month}-{day}",
+			prefix:   "backup/orders/partition=",
+			matcher:  "^backup/orders/partition=[0-9]+/2020-04-27/",
+			matches:  []string{"backup/orders/partition=12/2020-04-27/orders+12+0000000000.json"},
+			misses: []string{
+				"backup/orders/partition=12/2020-04-28/orders+12+0000000000.json",
+				"backup/orders/partition=x/2020-04-27/orders+0+0000000000.json",
+			},
+		},
+		{
+			template: "{partition}/{topic}",
+			prefix:   "",
+			matcher:  "^[0-9]+/orders/",
+			matches:  []string{"3/orders/orders+3+0000000000.json"},
+			misses:   []string{"3/orders-v2/orders-v2+3+0000000000.json"},
+		},
+	}
+
+	for _, test := range tests {
+		layout, err := newKeyLayout(test.template, "")
+		if err != nil {
+			t.Fatalf("newKeyLayout(%q): %v", test.template, err)
+		}
+		if prefix := layout.prefix("orders", period); prefix != test.prefix {
+			t.Errorf("%q: prefix is %q, want %q", test.template, prefix, test.prefix)
+		}
+
+		matcher := layout.matcher("orders", period)
+		if test.matcher == "" {
+			if matcher != nil {
+				t.Errorf("%q: matcher is %q, want none", test.template, matcher)
+			}
+			continue
+		}
+		if matcher == nil || matcher.String() != test.matcher {
+			t.Errorf("%q: matcher is %v, want %q", test.template, matcher, test.matcher)
+			continue
+		}
+		for _, key := range test.matches {
+			if !matcher.MatchString(key) {
+				t.Errorf("%q: matcher doesn't match %q", test.template, key)
+			}
+		}
+		for _, key := range test.misses {
+			if matcher.MatchString(key) {
+				t.Errorf("%q: matcher matches %q", test.template, key)
+			}
+		}
+	}
+}
+
+func TestParseConnectObjectName(t *testing.T) {
+	tests := []struct {
+		key         string
+		topic       string
+		partition   int32
+		startOffset int64
+		fails       bool
+	}{
+		{key: "topics/orders/orders+0+0000000000.json", topic: "orders", partition: 0, startOffset: 0},
+		{key: "orders+12+0000001500", topic: "orders", partition: 12, startOffset: 1500},
+		{key: "topics/orders.v1/orders.v1+3+0000000042.json.gz", topic: "orders.v1", partition: 3, startOffset: 42},
+		{key: "topics/a+b/a+b+1+0000000007.keys.json", topic: "a+b", partition: 1, startOffset: 7},
+		{key: "topics/orders/orders+2+9223372036854775807.avro", topic: "orders", partition: 2, startOffset: 9223372036854775807},
+		{key: "topics/orders/orders.json", fails: true},
+		{key: "topics/orders/orders+1.json", fails: true},
+		{key: "topics/orders/orders+x+0000000000.json", fails: true},
+		{key: "topics/orders/orders+-1+0000000000.json", fails: true},
+		{key: "topics/orders/+1+0000000000.json", fails: true},
+		{key: "topics/orders/orders+2147483648+0000000000.json", fails: true},
+		{key: "topics/orders/orders+0+9223372036854775808.json", fails: true},
+	}
+
+	for _, test := range tests {
+		topic, partition, startOffset, err := parseConnectObjectName(test.key)
+		if test.fails {
+			if err == nil {
+				t.Errorf("parseConnectObjectName(%q) = %s, %d, %d, want an error", test.key, topic, partition, startOffset)
+			}
+			continue
+		}
+		if err != nil || topic != test.topic || partition != test.partition || startOffset != test.startOffset {
+			t.Errorf("parseConnectObjectName(%q) = %s, %d, %d, %v, want %s, %d, %d", test.key, topic, partition, startOffset, err, test.topic, test.partition, test.startOffset)
+		}
+	}
+}
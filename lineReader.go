@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"time"
+)
+
+const (
+	defaultStreamBufferBytes = 64 * 1024
+	defaultMaxRecordBytes    = 1024 * 1024
+	defaultRecordsQueueSize  = 1000
+	defaultDownloadWorkers   = 4
+	// defaultObjectIdleLimit is kept under the time S3 leaves open a connection that isn't read from
+	defaultObjectIdleLimit = 20 * time.Second
+)
+
+// streamOptions configures how objects are streamed from S3 into Kafka.
+// Every one of the workers reads a single object through a read buffer of bufferBytes,
+// and queues at most recordsQueueSize records of up to maxRecordBytes each for the producer.
+type streamOptions struct {
+	bufferBytes      int
+	maxRecordBytes   int
+	recordsQueueSize int
+	workers          int
+	// idleLimit is how long the records of an object wait for room in its queue before the object is closed,
+	// to be read again from the next record once there's room
+	idleLimit time.Duration
+	// compression of the objects, detected from every object when it's auto
+	compression string
+	// objectFormat of the objects, detected from every object when it's auto
+	objectFormat string
+	// registry registers the schemas of Avro records, and may be nil when there are none
+	registry schemaRegistry
+	// parquet configures the records restored out of Parquet rows
+	parquet parquetOptions
+
+	// decoder rebuilds the records out of the lines of the objects
+	decoder *recordDecoder
+	// Records of objects marked for filtering are only kept inside the window
+	window     restoreWindow
+	timestamps *timestampExtractor
+	// checkpoint skips the records acknowledged before a restart, and may be nil
+	checkpoint *checkpointTracker
+}
+
+// restoreRecord is a single record read from a backup object, and where it was read from.
+// The Topic, Partition and Offset of the message are the original ones, and are -1 when they're unknown.
+type restoreRecord struct {
+	KafkaMessage
+	ObjectKey string
+	Line      int64
+	// tombstone is a record whose value is null rather than empty
+	tombstone bool
+	// sentAt is when the record was handed to the producer, for the produce latency
+	sentAt time.Time
+}
+
+// countingReader counts the bytes read through it, so a stream can be checked against its expected length
+type countingReader struct {
+	reader io.Reader
+	bytes  int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.bytes += int64(n)
+	return n, err
+}
+
+// lineReader splits a stream into newline-delimited records without loading the whole stream
+type lineReader struct {
+	reader         *bufio.Reader
+	maxRecordBytes int
+	line           int64
+	// keepBlank returns the blank lines too, which are records when the lines of side objects match them
+	keepBlank bool
+}
+
+// newLineReader returns a lineReader that reads through a buffer of bufferBytes
+func newLineReader(reader io.Reader, bufferBytes int, maxRecordBytes int) *lineReader {
+	return &lineReader{
+		reader:         bufio.NewReaderSize(reader, bufferBytes),
+		maxRecordBytes: maxRecordBytes,
+	}
+}
+
+// next returns the next non-empty line, or the next line with keepBlank, and io.EOF when the stream ends.
+// The returned slice is owned by the caller.
+func (r *lineReader) next() ([]byte, error) {
+	for {
+		record, err := r.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if len(record) > 0 || r.keepBlank {
+			return record, nil
+		}
+	}
+}
+
+// readLine returns the next line without its newline, copied out of the read buffer. A line ending with
+// "\r\n" loses both, so objects written on Windows read the same.
+func (r *lineReader) readLine() ([]byte, error) {
+	var record []byte
+	for {
+		chunk, err := r.reader.ReadSlice('\n')
+		// Until the line is complete, it may still hold its "\r\n"
+		if len(record)+len(chunk) > r.maxRecordBytes+2 {
+			return nil, r.errTooLong()
+		}
+		record = append(record, chunk...)
+
+		switch err {
+		case bufio.ErrBufferFull:
+			continue
+		case nil, io.EOF:
+			if err == io.EOF && len(record) == 0 {
+				return nil, io.EOF
+			}
+			record = bytes.TrimSuffix(bytes.TrimSuffix(record, []byte("\n")), []byte("\r"))
+			if len(record) > r.maxRecordBytes {
+				return nil, r.errTooLong()
+			}
+			r.line++
+			return record, nil
+		default:
+			return nil, err
+		}
+	}
+}
+
+// errTooLong is the error of the next line when it's over the max size
+func (r *lineReader) errTooLong() error {
+	return fmt.Errorf("line %d is longer than %d bytes", r.line+1, r.maxRecordBytes)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+// readAllLines returns every line of a lineReader, and the error that ended the stream unless it's io.EOF
+func readAllLines(reader *lineReader) ([]string, error) {
+	var lines []string
+	for {
+		line, err := reader.next()
+		if err == io.EOF {
+			return lines, nil
+		}
+		if err != nil {
+			return lines, err
+		}
+		lines = append(lines, string(line))
+	}
+}
+
+func TestLineReader(t *testing.T) {
+	tests := []struct {
+		name           string
+		input          string
+		bufferBytes    int
+		maxRecordBytes int
+		keepBlank      bool
+		lines          []string
+		lastLine       int64
+		fails          bool
+	}{
+		{name: "empty", input: "", lines: nil},
+		{name: "trailing newline", input: "a\nb\n", lines: []string{"a", "b"}, lastLine: 2},
+		{name: "no trailing newline", input: "a\nb", lines: []string{"a", "b"}, lastLine: 2},
+		{name: "blank lines", input: "\na\n\n\nb\n\n", lines: []string{"a", "b"}, lastLine: 6},
+		{name: "kept blank lines", input: "\na\n\nb\n", keepBlank: true, lines: []string{"", "a", "", "b"}, lastLine: 4},
+		{name: "kept blank last line", input: "a\n\n", keepBlank: true, lines: []string{"a", ""}, lastLine: 2},
+		{name: "crlf", input: "a\r\nb\r\n", lines: []string{"a", "b"}, lastLine: 2},
+		{name: "crlf without a trailing newline", input: "a\r\nb\r", lines: []string{"a", "b"}, lastLine: 2},
+		{name: "crlf blank lines", input: "\r\na\r\n\r\n", keepBlank: true, lines: []string{"", "a", ""}, lastLine: 3},
+		{name: "carriage return inside a line", input: "a\rb\r\n", lines: []string{"a\rb"}, lastLine: 1},
+		{
+			name:        "lines longer than the buffer",
+			input:       strings.Repeat("x", 40) + "\n" + strings.Repeat("y", 17),
+			bufferBytes: 16,
+			lines:       []string{strings.Repeat("x", 40), strings.Repeat("y", 17)},
+			lastLine:    2,
+		},
+		{name: "line of the max size", input: "12345\n", maxRecordBytes: 5, lines: []string{"12345"}, lastLine: 1},
+		{name: "line over the max size", input: "ok\n123456\n", maxRecordBytes: 5, lines: []string{"ok"}, fails: true},
+		{name: "crlf line of the max size", input: "12345\r\n", maxRecordBytes: 5, lines: []string{"12345"}, lastLine: 1},
+		{name: "last line over the max size", input: "ok\n123456", maxRecordBytes: 5, lines: []string{"ok"}, fails: true},
+	}
+
+	for _, test := range tests {
+		if test.bufferBytes == 0 {
+			test.bufferBytes = defaultStreamBufferBytes
+		}
+		if test.maxRecordBytes == 0 {
+			test.maxRecordBytes = defaultMaxRecordBytes
+		}
+		reader := newLineReader(strings.NewReader(test.input), test.bufferBytes, test.maxRecordBytes)
+		reader.keepBlank = test.keepBlank
+		lines, err := readAllLines(reader)
+		if test.fails != (err != nil) {
+			t.Errorf("%s: error is %v, want an error: %v", test.name, err, test.fails)
+		}
+		if strings.Join(lines, "|") != strings.Join(test.lines, "|") || len(lines) != len(test.lines) {
+			t.Errorf("%s: lines are %q, want %q", test.name, lines, test.lines)
+		}
+		if !test.fails && reader.line != test.lastLine {
+			t.Errorf("%s: last line is %d, want %d", test.name, reader.line, test.lastLine)
+		}
+	}
+}
@@ -1,33 +1,49 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"log"
+	"io"
 	"os"
 	"path"
+	"strings"
+	"sync"
 	"time"
-
-	"github.com/spf13/viper"
 )
 
 const (
-	logLevelInfo   = "INFO"
-	logLevelError  = "ERROR"
-	logLevelPanic  = "PANIC"
+	logLevelDebug   = "DEBUG"
+	logLevelInfo    = "INFO"
 	logLevelWarning = "WARNING"
-	componentKafka = "Kafka Producer"
-	componentS3    = "S3 client"
-	componentMain  = "Main"
-	componentAuth  = "Authentication"
-	logfileAdmin   = "admin"
-	timeFormat     = "2006-01-02 15:04:05.000"
+	logLevelError   = "ERROR"
+	logLevelPanic   = "PANIC"
+	componentKafka  = "Kafka Producer"
+	componentS3     = "S3 client"
+	componentMain   = "Main"
+	componentAuth   = "Authentication"
+	logfileAdmin    = "admin"
+	timeFormat      = "2006-01-02 15:04:05.000"
+
+	defaultLogFileMaxBytes   = 100 * 1024 * 1024
+	defaultLogFileMaxBackups = 3
 )
 
+// logLevels orders the levels, from the most verbose
+var logLevels = map[string]int{
+	logLevelDebug:   0,
+	logLevelInfo:    1,
+	logLevelWarning: 2,
+	logLevelError:   3,
+	logLevelPanic:   4,
+}
+
 // logEvent represents a generic log event that is written into a log file
 type logEvent struct {
 	Time      string      `json:"time"`
 	Host      string      `json:"host"`
+	RunID     string      `json:"run_id"`
 	Loglevel  string      `json:"loglevel"`
 	Component string      `json:"component"`
 	Event     interface{} `json:"event"`
@@ -35,11 +51,19 @@ type logEvent struct {
 
 // KafkaMessage represents kafka message was produced into a kafka topic
 type KafkaMessage struct {
-	Key       string `json:"key"`
-	Value     string `json:"value"`
-	Topic     string `json:"topic"`
-	Partition int32  `json:"partition"`
-	Offset    int64  `json:"offset"`
+	Key       string        `json:"key"`
+	Value     string        `json:"value"`
+	Headers   []KafkaHeader `json:"headers,omitempty"`
+	Timestamp time.Time     `json:"timestamp"`
+	Topic     string        `json:"topic"`
+	Partition int32         `json:"partition"`
+	Offset    int64         `json:"offset"`
+}
+
+// KafkaHeader represents a header of a kafka message
+type KafkaHeader struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
 }
 
 // ErrorLog represents an error log
@@ -48,36 +72,186 @@ type ErrorLog struct {
 	Message     string `json:"message"`
 }
 
-// WriteLog write log as logEvent structure into a log file
-func WriteLog(logfile string, loglevel string, component string, event interface{}) {
-	// Get host name
-	hostname, err := os.Hostname()
+// logger is the logger of the restore. Until configureLogger runs, it writes every event except the
+// debug ones to stdout.
+var logger = &restoreLogger{level: logLevels[logLevelInfo], host: hostname(), runID: newRunID(), outputs: []io.Writer{os.Stdout}}
+
+// restoreLogger writes the log events as JSON lines into every one of its outputs, with their secrets redacted.
+// Every event carries the run ID, so the events of a restore can be told apart from the previous runs.
+type restoreLogger struct {
+	mutex   sync.Mutex
+	level   int
+	host    string
+	runID   string
+	outputs []io.Writer
+}
+
+// loggerOptions configures the logger out of the configuration
+type loggerOptions struct {
+	level  string
+	runID  string
+	stdout bool
+	// file is the path of the log file, and is empty to disable it
+	file           string
+	fileMaxBytes   int64
+	fileMaxBackups int
+}
+
+// logFilePath is the path of the log file in a log directory
+func logFilePath(logdir string) string {
+	return fmt.Sprintf("%s.log", path.Join(logdir, logfileAdmin))
+}
+
+// configureLogger sets the level, run ID and outputs of the logger
+func configureLogger(options loggerOptions) error {
+	level, ok := logLevels[strings.ToUpper(options.level)]
+	if !ok {
+		return fmt.Errorf("unknown log level %q, expected %s, %s, %s or %s", options.level, logLevelDebug, logLevelInfo, logLevelWarning, logLevelError)
+	}
+
+	var outputs []io.Writer
+	if options.stdout {
+		outputs = append(outputs, os.Stdout)
+	}
+	if options.file != "" {
+		file, err := newRotatingFile(options.file, options.fileMaxBytes, options.fileMaxBackups)
+		if err != nil {
+			return fmt.Errorf("error opening log file %s: %v", options.file, err)
+		}
+		outputs = append(outputs, file)
+	}
+
+	logger.mutex.Lock()
+	defer logger.mutex.Unlock()
+	logger.level = level
+	if options.runID != "" {
+		logger.runID = options.runID
+	}
+	logger.outputs = outputs
+	return nil
+}
+
+// log writes an event of a level, unless the level is filtered out
+func (l *restoreLogger) log(level string, component string, event interface{}) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if logLevels[level] < l.level {
+		return
+	}
+	event = secrets.redactEvent(event)
+	data, err := json.Marshal(logEvent{time.Now().Format(timeFormat), l.host, l.runID, level, component, event})
 	if err != nil {
-		hostname = ""
+		data, _ = json.Marshal(logEvent{time.Now().Format(timeFormat), l.host, l.runID, level, component, fmt.Sprint(event)})
 	}
+	data = append(data, '\n')
+	for _, output := range l.outputs {
+		if _, err := output.Write(data); err != nil {
+			fmt.Fprintf(os.Stderr, "error writing log event: %v\n", err)
+		}
+	}
+}
 
-	// Build log event
-	logEventBytes, err := json.Marshal(logEvent{time.Now().Format(timeFormat), hostname, loglevel, component, event})
+func (l *restoreLogger) debug(component string, event interface{}) {
+	l.log(logLevelDebug, component, event)
+}
+
+func (l *restoreLogger) info(component string, event interface{}) {
+	l.log(logLevelInfo, component, event)
+}
+
+func (l *restoreLogger) warning(component string, event interface{}) {
+	l.log(logLevelWarning, component, event)
+}
+
+func (l *restoreLogger) error(component string, event interface{}) {
+	l.log(logLevelError, component, event)
+}
+
+// fatal logs an event the restore can't recover from, right before the caller panics
+func (l *restoreLogger) fatal(component string, event interface{}) {
+	l.log(logLevelPanic, component, event)
+}
+
+// hostname is the host of the log events, and is empty when it's unknown
+func hostname() string {
+	name, err := os.Hostname()
 	if err != nil {
-		logEventBytes = []byte{}
+		return ""
+	}
+	return name
+}
+
+// newRunID returns a random ID for the events of this run
+func newRunID() string {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
 	}
-	logEvent := string(logEventBytes)
+	return hex.EncodeToString(id)
+}
+
+// rotatingFile appends to a file, and moves it aside once it reaches maxBytes. The file is renamed to
+// <path>.1, the older ones shift to <path>.2 and so on, and only maxBackups of them are kept.
+// A maxBytes of 0 never rotates.
+type rotatingFile struct {
+	path       string
+	maxBytes   int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
 
-	// Open log file
-	f, err := os.OpenFile(fmt.Sprintf("%s.log", path.Join(viper.GetString(configLogDir), logfile)),
-		os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+func newRotatingFile(filePath string, maxBytes int64, maxBackups int) (*rotatingFile, error) {
+	if dir := path.Dir(filePath); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+	file := &rotatingFile{path: filePath, maxBytes: maxBytes, maxBackups: maxBackups}
+	return file, file.open()
+}
 
-	// Handle error in opening log file
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
 	if err != nil {
-		log.Println(err.Error())
-		// Cannot write into log file, print log
-		log.Println(logEvent)
+		file.Close()
+		return err
 	}
-	defer f.Close()
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
 
-	// Create logger
-	logger := log.New(f, "", 0)
+// Write is only called by the logger, with its mutex held
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	if f.maxBytes > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxBytes {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
 
-	// Write log event into log file using the logger
-	logger.Println(logEvent)
+func (f *rotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	if f.maxBackups > 0 {
+		os.Remove(fmt.Sprintf("%s.%d", f.path, f.maxBackups))
+		for backup := f.maxBackups - 1; backup >= 1; backup-- {
+			os.Rename(fmt.Sprintf("%s.%d", f.path, backup), fmt.Sprintf("%s.%d", f.path, backup+1))
+		}
+		if err := os.Rename(f.path, f.path+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(f.path); err != nil {
+		return err
+	}
+	return f.open()
 }
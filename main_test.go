@@ -0,0 +1,34 @@
+package main
+
+import "testing"
+
+func TestRequiredPartitions(t *testing.T) {
+	tests := []struct {
+		name       string
+		partitions []int32
+		required   int32
+		fails      bool
+	}{
+		{name: "no objects", partitions: nil, required: 0},
+		{name: "first partition", partitions: []int32{0, 0}, required: 1},
+		{name: "highest partition", partitions: []int32{2, 0, 5, 1}, required: 6},
+		{name: "unknown partition", partitions: []int32{0, -1}, fails: true},
+	}
+
+	for _, test := range tests {
+		var objects []restoreObject
+		for _, partition := range test.partitions {
+			objects = append(objects, restoreObject{Key: "orders+0+0000000000.json", Partition: partition})
+		}
+		required, err := requiredPartitions(objects)
+		if test.fails {
+			if err == nil {
+				t.Errorf("%s: requiredPartitions = %d, want an error", test.name, required)
+			}
+			continue
+		}
+		if err != nil || required != test.required {
+			t.Errorf("%s: requiredPartitions = %d, %v, want %d", test.name, required, err, test.required)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	metricsNamespace = "kafka_restore"
+	metricsPath      = "/metrics"
+)
+
+// The metrics of the restore. They're always collected, and only served when a metrics address is configured.
+var (
+	objectsListed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "objects_listed_total",
+		Help:      "Backup objects listed for the restore, by source topic.",
+	}, []string{"topic"})
+	objectsDownloaded = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "objects_downloaded_total",
+		Help:      "Backup objects read completely, by source topic.",
+	}, []string{"topic"})
+	objectsFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "objects_failed_total",
+		Help:      "Backup objects that failed to read, by source topic.",
+	}, []string{"topic"})
+	bytesDownloaded = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "bytes_downloaded_total",
+		Help:      "Stored bytes of the backup objects read completely, by source topic.",
+	}, []string{"topic"})
+
+	recordsSent = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "records_sent_total",
+		Help:      "Records handed to the producer, by target topic.",
+	}, []string{"topic"})
+	recordsAcked = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "records_acked_total",
+		Help:      "Records acknowledged by the brokers, by target topic and partition.",
+	}, []string{"topic", "partition"})
+	recordsFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "records_failed_total",
+		Help:      "Records the producer gave up on, by target topic and partition.",
+	}, []string{"topic", "partition"})
+	recordsDeadLettered = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "records_dead_lettered_total",
+		Help:      "Failed records written into the dead-letter sink, by target topic.",
+	}, []string{"topic"})
+	produceLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "produce_latency_seconds",
+		Help:      "Time from handing a record to the producer until its acknowledgement or failure, by target topic.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 16),
+	}, []string{"topic"})
+
+	s3RequestLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "s3_request_duration_seconds",
+		Help:      "Duration of the S3 requests until their response, retries included, by operation.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation"})
+	s3RequestErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "s3_request_errors_total",
+		Help:      "S3 requests that failed, by operation and error code.",
+	}, []string{"operation", "code"})
+
+	currentPeriod = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "current_period_timestamp_seconds",
+		Help:      "Start of the layout period of the object being restored, by source topic.",
+	}, []string{"topic"})
+	currentObject = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "current_object",
+		Help:      "Set to 1 for the object being restored, by source topic and object key.",
+	}, []string{"topic", "key"})
+	currentObjects      = make(map[string]string)
+	currentObjectsMutex sync.Mutex
+)
+
+// instrumentS3Client times every request of an S3 client, and counts its errors
+func instrumentS3Client(client *s3.S3) {
+	client.Handlers.Complete.PushBackNamed(request.NamedHandler{
+		Name: "kafka-restore.metrics",
+		Fn: func(r *request.Request) {
+			s3RequestLatency.WithLabelValues(r.Operation.Name).Observe(time.Since(r.Time).Seconds())
+			if r.Error != nil {
+				code := "unknown"
+				if aerr, ok := r.Error.(awserr.Error); ok {
+					code = aerr.Code()
+				}
+				s3RequestErrors.WithLabelValues(r.Operation.Name, code).Inc()
+			}
+		},
+	})
+}
+
+// observeObjectStarted moves the position of the restore to an object
+func observeObjectStarted(object restoreObject) {
+	// Layouts without date variables have no period
+	if !object.Period.IsZero() {
+		currentPeriod.WithLabelValues(object.Topic).Set(float64(object.Period.Unix()))
+	}
+
+	currentObjectsMutex.Lock()
+	defer currentObjectsMutex.Unlock()
+	if previous, ok := currentObjects[object.Topic]; ok {
+		currentObject.DeleteLabelValues(object.Topic, previous)
+	}
+	currentObjects[object.Topic] = object.Key
+	currentObject.WithLabelValues(object.Topic, object.Key).Set(1)
+}
+
+// observeObjectFinished counts an object that was read, or failed to
+func observeObjectFinished(object restoreObject, err error) {
+	if err != nil {
+		objectsFailed.WithLabelValues(object.Topic).Inc()
+		return
+	}
+	objectsDownloaded.WithLabelValues(object.Topic).Inc()
+	bytesDownloaded.WithLabelValues(object.Topic).Add(float64(object.Size))
+}
+
+// observeProduced times a record from its hand-off to the producer until its acknowledgement or failure
+func observeProduced(topic string, record *restoreRecord) {
+	if !record.sentAt.IsZero() {
+		produceLatency.WithLabelValues(topic).Observe(time.Since(record.sentAt).Seconds())
+	}
+}
+
+func partitionLabel(partition int32) string {
+	return strconv.Itoa(int(partition))
+}
+
+// metricsHandler serves the metrics in the Prometheus text format
+func metricsHandler() http.Handler {
+	return promhttp.Handler()
+}
@@ -0,0 +1,547 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/linkedin/goavro/v2"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/reader"
+	"github.com/xitongsys/parquet-go/source"
+)
+
+const (
+	// parquetValueJSON restores every row as a JSON object of its columns
+	parquetValueJSON = "json"
+	// parquetValueAvro restores every row as an Avro record in the Confluent wire format
+	parquetValueAvro = "avro"
+
+	// parquetReadRows is the number of rows read out of the column chunks at once
+	parquetReadRows = 100
+
+	// julianDayUnixEpoch is the Julian day of 1970-01-01, the epoch of INT96 timestamps
+	julianDayUnixEpoch = 2440588
+)
+
+var invalidAvroName = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// parquetOptions configures how the rows of Parquet objects are restored
+type parquetOptions struct {
+	valueFormat string
+	// keyColumn and timestampColumn are the dotted paths of the columns holding the record key and timestamp,
+	// and are empty when the rows have none
+	keyColumn       string
+	timestampColumn string
+}
+
+// validateParquetOptions checks the configured Parquet value format
+func validateParquetOptions(options parquetOptions) error {
+	switch options.valueFormat {
+	case parquetValueJSON, parquetValueAvro:
+		return nil
+	}
+	return fmt.Errorf("unknown parquet value format %q, expected %s or %s", options.valueFormat, parquetValueJSON, parquetValueAvro)
+}
+
+// s3ParquetFile reads an S3 object through ranged GetObject calls, for the Parquet reader to seek to the
+// footer and to the column chunks. Reads after a seek start a new range, sequential reads keep streaming it.
+type s3ParquetFile struct {
+	s3Client *s3.S3
+	bucket   string
+	key      string
+	size     int64
+
+	offset     int64
+	body       io.ReadCloser
+	bodyOffset int64
+
+	// err is the first read error of the object, shared by the files opened for every column.
+	// The Parquet reader drops the errors of the column chunks, and reads fewer rows instead.
+	err *error
+}
+
+// newS3ParquetFile returns a file over an object of size bytes
+func newS3ParquetFile(s3Client *s3.S3, bucket string, key string, size int64) *s3ParquetFile {
+	return &s3ParquetFile{s3Client: s3Client, bucket: bucket, key: key, size: size, err: new(error)}
+}
+
+func (f *s3ParquetFile) Read(p []byte) (int, error) {
+	if f.offset >= f.size {
+		return 0, io.EOF
+	}
+	if f.body == nil || f.bodyOffset != f.offset {
+		f.closeBody()
+		output, err := f.s3Client.GetObject(&s3.GetObjectInput{
+			Bucket: aws.String(f.bucket),
+			Key:    aws.String(f.key),
+			Range:  aws.String(fmt.Sprintf("bytes=%d-", f.offset)),
+		})
+		if err != nil {
+			return 0, f.fail(err)
+		}
+		f.body = output.Body
+		f.bodyOffset = f.offset
+	}
+
+	n, err := f.body.Read(p)
+	f.offset += int64(n)
+	f.bodyOffset += int64(n)
+	if err == io.EOF && f.offset < f.size {
+		return n, f.fail(fmt.Errorf("object %s ended after %d bytes out of %d", f.key, f.offset, f.size))
+	}
+	if err != nil && err != io.EOF {
+		return n, f.fail(err)
+	}
+	return n, err
+}
+
+func (f *s3ParquetFile) fail(err error) error {
+	if *f.err == nil {
+		*f.err = fmt.Errorf("error reading %s: %v", f.key, err)
+	}
+	return err
+}
+
+func (f *s3ParquetFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.size
+	}
+	if offset < 0 {
+		return f.offset, fmt.Errorf("invalid seek to %d in %s", offset, f.key)
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+// Open returns another file over the same object
+func (f *s3ParquetFile) Open(name string) (source.ParquetFile, error) {
+	return &s3ParquetFile{s3Client: f.s3Client, bucket: f.bucket, key: f.key, size: f.size, err: f.err}, nil
+}
+
+func (f *s3ParquetFile) Create(name string) (source.ParquetFile, error) {
+	return nil, fmt.Errorf("backup objects are read only")
+}
+
+func (f *s3ParquetFile) Write(p []byte) (int, error) {
+	return 0, fmt.Errorf("backup objects are read only")
+}
+
+func (f *s3ParquetFile) closeBody() {
+	if f.body != nil {
+		f.body.Close()
+		f.body = nil
+	}
+}
+
+func (f *s3ParquetFile) Close() error {
+	f.closeBody()
+	return nil
+}
+
+// parquetObject reads the rows of a Parquet object. The column chunks of one row group are held at a time,
+// and only parquetReadRows rows are decoded at once, whatever the number of row groups.
+type parquetObject struct {
+	key    string
+	file   *s3ParquetFile
+	reader *reader.ParquetReader
+	// names are the column names, by the names of the struct fields the rows are decoded into
+	names map[string]string
+	// elements are the schema elements of the columns, by their dotted path
+	elements map[string]*parquet.SchemaElement
+
+	batch []interface{}
+	rows  int64
+}
+
+// openParquetObject reads the footer of a Parquet object
+func openParquetObject(s3Client *s3.S3, bucket string, key string, size int64) (*parquetObject, error) {
+	file := newS3ParquetFile(s3Client, bucket, key, size)
+	parquetReader, err := reader.NewParquetReader(file, nil, 1)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("error reading Parquet footer of %s: %v", key, err)
+	}
+
+	object := &parquetObject{
+		key:      key,
+		file:     file,
+		reader:   parquetReader,
+		names:    make(map[string]string),
+		elements: make(map[string]*parquet.SchemaElement),
+	}
+	handler := parquetReader.SchemaHandler
+	for i, info := range handler.Infos {
+		object.names[info.InName] = info.ExName
+		if i == 0 {
+			continue
+		}
+		// The paths start with the name of the root element
+		path := strings.SplitN(handler.InPathToExPath[handler.IndexMap[int32(i)]], ".", 2)
+		object.elements[path[len(path)-1]] = handler.SchemaElements[i]
+	}
+	return object, nil
+}
+
+// next returns the next row of the object as a map of its columns, and io.EOF after the last one
+func (o *parquetObject) next() (map[string]interface{}, error) {
+	if len(o.batch) == 0 {
+		remaining := o.reader.GetNumRows() - o.rows
+		if remaining <= 0 {
+			return nil, io.EOF
+		}
+		if remaining > parquetReadRows {
+			remaining = parquetReadRows
+		}
+		batch, err := o.reader.ReadByNumber(int(remaining))
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s after row %d: %v", o.key, o.rows, err)
+		}
+		if len(batch) == 0 {
+			return nil, io.EOF
+		}
+		o.batch = batch
+	}
+
+	row := o.batch[0]
+	o.batch = o.batch[1:]
+	o.rows++
+	native, ok := o.native(reflect.ValueOf(row)).(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("row %d of %s isn't a record", o.rows, o.key)
+	}
+	return native, nil
+}
+
+// native converts a decoded row into maps, slices and plain values, named after the columns
+func (o *parquetObject) native(value reflect.Value) interface{} {
+	switch value.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if value.IsNil() {
+			return nil
+		}
+		return o.native(value.Elem())
+	case reflect.Struct:
+		fields := make(map[string]interface{}, value.NumField())
+		for i := 0; i < value.NumField(); i++ {
+			name := value.Type().Field(i).Name
+			if exName, ok := o.names[name]; ok {
+				name = exName
+			}
+			fields[name] = o.native(value.Field(i))
+		}
+		return fields
+	case reflect.Slice:
+		items := make([]interface{}, value.Len())
+		for i := range items {
+			items[i] = o.native(value.Index(i))
+		}
+		return items
+	case reflect.Map:
+		entries := make(map[string]interface{}, value.Len())
+		for _, key := range value.MapKeys() {
+			entries[fmt.Sprint(o.native(key))] = o.native(value.MapIndex(key))
+		}
+		return entries
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return value.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return value.Uint()
+	case reflect.Float32, reflect.Float64:
+		return value.Float()
+	}
+	return value.Interface()
+}
+
+// verify checks that every row of the footer was read, since the reader drops the errors of the column chunks
+func (o *parquetObject) verify() error {
+	if *o.file.err != nil {
+		return *o.file.err
+	}
+	if o.rows != o.reader.GetNumRows() {
+		return fmt.Errorf("object %s is incomplete: read %d rows out of %d", o.key, o.rows, o.reader.GetNumRows())
+	}
+	return nil
+}
+
+func (o *parquetObject) Close() error {
+	o.reader.ReadStop()
+	return o.file.Close()
+}
+
+// column returns the value of a column of a row by its dotted path
+func column(row map[string]interface{}, path string) (interface{}, bool) {
+	var value interface{} = row
+	for _, field := range strings.Split(path, ".") {
+		fields, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = fields[field]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// columnText returns the text of a column for the record key. Text is kept as it is, nulls are empty, and any
+// other value is written as JSON.
+func columnText(value interface{}) (string, error) {
+	switch value := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return value, nil
+	}
+	data, err := json.Marshal(value)
+	return string(data), err
+}
+
+// columnTimestamp converts a column into a time according to its Parquet type. Columns without a timestamp
+// type are parsed like the record timestamps of the other formats.
+func columnTimestamp(value interface{}, element *parquet.SchemaElement, timestamps *timestampExtractor) (time.Time, bool) {
+	if value == nil {
+		return time.Time{}, false
+	}
+	if element != nil && element.GetType() == parquet.Type_INT96 {
+		if text, ok := value.(string); ok && len(text) == 12 {
+			nanos := int64(binary.LittleEndian.Uint64([]byte(text[:8])))
+			day := int64(binary.LittleEndian.Uint32([]byte(text[8:])))
+			return time.Unix((day-julianDayUnixEpoch)*24*60*60, nanos).UTC(), true
+		}
+		return time.Time{}, false
+	}
+	if number, ok := value.(int64); ok && element != nil && element.ConvertedType != nil {
+		switch element.GetConvertedType() {
+		case parquet.ConvertedType_TIMESTAMP_MILLIS:
+			return time.Unix(0, number*int64(time.Millisecond)).UTC(), true
+		case parquet.ConvertedType_TIMESTAMP_MICROS:
+			return time.Unix(0, number*int64(time.Microsecond)).UTC(), true
+		case parquet.ConvertedType_DATE:
+			return time.Unix(number*24*60*60, 0).UTC(), true
+		}
+	}
+
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return timestamps.parse(raw)
+}
+
+// parquetAvroSchema derives an Avro record schema from the top level columns of a Parquet object.
+// Optional columns become unions with null, and nested columns aren't supported.
+func (o *parquetObject) parquetAvroSchema() (string, error) {
+	handler := o.reader.SchemaHandler
+	root := handler.SchemaElements[0]
+	if int(root.GetNumChildren()) != len(handler.SchemaElements)-1 {
+		return "", fmt.Errorf("object %s has nested columns, which can't be restored as Avro records", o.key)
+	}
+
+	fields := make([]map[string]interface{}, 0, root.GetNumChildren())
+	for i, element := range handler.SchemaElements[1:] {
+		name := handler.Infos[i+1].ExName
+		if element.GetRepetitionType() == parquet.FieldRepetitionType_REPEATED {
+			return "", fmt.Errorf("object %s has the repeated column %s, which can't be restored as Avro records", o.key, name)
+		}
+		var fieldType interface{} = avroTypeOfColumn(element)
+		field := map[string]interface{}{"name": invalidAvroName.ReplaceAllString(name, "_")}
+		if element.GetRepetitionType() == parquet.FieldRepetitionType_OPTIONAL {
+			field["type"] = []interface{}{"null", fieldType}
+			field["default"] = nil
+		} else {
+			field["type"] = fieldType
+		}
+		fields = append(fields, field)
+	}
+
+	schema, err := json.Marshal(map[string]interface{}{
+		"type":   "record",
+		"name":   invalidAvroName.ReplaceAllString(handler.Infos[0].ExName, "_"),
+		"fields": fields,
+	})
+	return string(schema), err
+}
+
+// avroTypeOfColumn returns the Avro type of a primitive Parquet column
+func avroTypeOfColumn(element *parquet.SchemaElement) string {
+	switch element.GetType() {
+	case parquet.Type_BOOLEAN:
+		return "boolean"
+	case parquet.Type_INT32:
+		if element.ConvertedType != nil && element.GetConvertedType() == parquet.ConvertedType_UINT_32 {
+			return "long"
+		}
+		return "int"
+	case parquet.Type_INT64:
+		return "long"
+	case parquet.Type_FLOAT:
+		return "float"
+	case parquet.Type_DOUBLE:
+		return "double"
+	case parquet.Type_BYTE_ARRAY:
+		if element.ConvertedType != nil && (element.GetConvertedType() == parquet.ConvertedType_UTF8 ||
+			element.GetConvertedType() == parquet.ConvertedType_JSON || element.GetConvertedType() == parquet.ConvertedType_ENUM) {
+			return "string"
+		}
+	}
+	return "bytes"
+}
+
+// avroNative converts a row into the native form of the Avro schema derived from the columns
+func (o *parquetObject) avroNative(row map[string]interface{}) map[string]interface{} {
+	handler := o.reader.SchemaHandler
+	native := make(map[string]interface{}, len(handler.SchemaElements)-1)
+	for i, element := range handler.SchemaElements[1:] {
+		name := handler.Infos[i+1].ExName
+		value := row[name]
+		fieldType := avroTypeOfColumn(element)
+		switch fieldType {
+		case "int":
+			if number, ok := value.(int64); ok {
+				value = int32(number)
+			}
+		case "long":
+			if number, ok := value.(uint64); ok {
+				value = int64(number)
+			}
+		case "float":
+			if number, ok := value.(float64); ok {
+				value = float32(number)
+			}
+		case "bytes":
+			if text, ok := value.(string); ok {
+				value = []byte(text)
+			}
+		}
+		if element.GetRepetitionType() == parquet.FieldRepetitionType_OPTIONAL {
+			value = goavro.Union(fieldType, value)
+			if row[name] == nil {
+				value = nil
+			}
+		}
+		native[invalidAvroName.ReplaceAllString(name, "_")] = value
+	}
+	return native
+}
+
+// streamParquetObject reads the rows of a Parquet object and sends every one of them with the sender,
+// as a JSON object or as an Avro record registered under the <target>-value subject. The key and timestamp of
+// the records are read from their configured columns.
+func streamParquetObject(ctx context.Context, s3Client *s3.S3, bucket string, object restoreObject, options streamOptions, sender *recordSender) error {
+	rows, err := openParquetObject(s3Client, bucket, object.Key, object.Size)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var codec *goavro.Codec
+	header := make([]byte, wireFormatHeaderSize)
+	if options.parquet.valueFormat == parquetValueAvro {
+		if options.registry == nil {
+			return fmt.Errorf("object %s is restored as Avro records, and no schema registry is configured for its schema", object.Key)
+		}
+		schema, err := rows.parquetAvroSchema()
+		if err != nil {
+			return err
+		}
+		if codec, err = goavro.NewCodec(schema); err != nil {
+			return fmt.Errorf("error deriving the Avro schema of %s: %v", object.Key, err)
+		}
+		id, err := options.registry.register(object.Target+subjectValueSuffix, codec.Schema())
+		if err != nil {
+			return err
+		}
+		header[0] = wireFormatMagic
+		binary.BigEndian.PutUint32(header[1:], uint32(id))
+	}
+	timestampElement := rows.elements[options.parquet.timestampColumn]
+	timestampLayout := &timestampExtractor{}
+	if options.decoder != nil {
+		timestampLayout = options.decoder.timestamps
+	}
+
+	recordsCount := 0
+	for index := int64(0); ; index++ {
+		row, err := rows.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		record := newRestoreRecord(object, index, rows.rows)
+		var value []byte
+		if codec != nil {
+			value, err = codec.BinaryFromNative(header, rows.avroNative(row))
+		} else {
+			value, err = json.Marshal(row)
+		}
+		if err != nil {
+			return fmt.Errorf("error encoding row %d of %s: %v", rows.rows, object.Key, err)
+		}
+		if len(value) > options.maxRecordBytes {
+			return fmt.Errorf("row %d of %s is longer than %d bytes", rows.rows, object.Key, options.maxRecordBytes)
+		}
+		record.Value = string(value)
+
+		if options.parquet.keyColumn != "" {
+			if key, ok := column(row, options.parquet.keyColumn); ok {
+				if record.Key, err = columnText(key); err != nil {
+					return fmt.Errorf("invalid key of row %d of %s: %v", rows.rows, object.Key, err)
+				}
+			}
+		}
+		if options.parquet.timestampColumn != "" {
+			if timestamp, ok := column(row, options.parquet.timestampColumn); ok {
+				record.Timestamp, _ = columnTimestamp(timestamp, timestampElement, timestampLayout)
+			}
+		}
+
+		if object.filter {
+			timestamp := record.Timestamp
+			if timestamp.IsZero() && options.timestamps != nil {
+				timestamp, _ = options.timestamps.extractNative(row)
+			}
+			if !timestamp.IsZero() && !options.window.contains(timestamp) {
+				continue
+			}
+		}
+		if options.checkpoint.skip(record) {
+			continue
+		}
+		if err := sender.send(ctx, record); err != nil {
+			return err
+		}
+		recordsCount++
+	}
+
+	if err := rows.verify(); err != nil {
+		return err
+	}
+	logger.info(componentS3, fmt.Sprintf("Read %d records to restore (%d rows) from %s", recordsCount, rows.rows, object.Key))
+	return nil
+}
+
+// countParquetRows returns the number of rows in the footer of a Parquet object
+func countParquetRows(s3Client *s3.S3, bucket string, key string, size int64) (int64, error) {
+	file := newS3ParquetFile(s3Client, bucket, key, size)
+	defer file.Close()
+	footer := &reader.ParquetReader{PFile: file}
+	if err := footer.ReadFooter(); err != nil {
+		return 0, err
+	}
+	return footer.GetNumRows(), nil
+}
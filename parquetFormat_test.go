@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/linkedin/goavro/v2"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+type testParquetOrder struct {
+	ID      int64   `parquet:"name=id, type=INT64"`
+	Name    *string `parquet:"name=name, type=UTF8, repetitiontype=OPTIONAL"`
+	Amount  float32 `parquet:"name=amount, type=FLOAT"`
+	Created string  `parquet:"name=created, type=INT96"`
+}
+
+type testParquetCustomer struct {
+	ID      int32              `parquet:"name=id, type=INT32"`
+	Address testParquetAddress `parquet:"name=address"`
+}
+
+type testParquetAddress struct {
+	City string `parquet:"name=city, type=UTF8"`
+	Zip  *int32 `parquet:"name=zip, type=INT32, repetitiontype=OPTIONAL"`
+}
+
+// schemaRecorder registers every schema under the same id, and keeps the last one
+type schemaRecorder struct {
+	subject string
+	schema  string
+}
+
+func (r *schemaRecorder) register(subject string, schema string) (int, error) {
+	r.subject = subject
+	r.schema = schema
+	return 7, nil
+}
+
+// writeTestParquet returns a Parquet file of the rows, which are values of the struct of obj
+func writeTestParquet(t *testing.T, obj interface{}, rows ...interface{}) string {
+	var file bytes.Buffer
+	parquetWriter, err := writer.NewParquetWriterFromWriter(&file, obj, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range rows {
+		if err := parquetWriter.Write(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := parquetWriter.WriteStop(); err != nil {
+		t.Fatal(err)
+	}
+	return file.String()
+}
+
+// int96Timestamp encodes a time as an INT96 timestamp: the nanoseconds of the day, then the Julian day
+func int96Timestamp(timestamp time.Time) string {
+	data := make([]byte, 12)
+	day := timestamp.Unix() / (24 * 60 * 60)
+	binary.LittleEndian.PutUint64(data, uint64(timestamp.UnixNano()-day*24*60*60*int64(time.Second)))
+	binary.LittleEndian.PutUint32(data[8:], uint32(day+julianDayUnixEpoch))
+	return string(data)
+}
+
+// streamTestParquet streams a Parquet object with the options, and returns its records
+func streamTestParquet(t *testing.T, body string, options parquetOptions, registry schemaRegistry) ([]*restoreRecord, error) {
+	server := httptest.NewServer(newFakeS3(map[string]*fakeS3Object{"rows.parquet": {body: body}}))
+	defer server.Close()
+
+	streamOptions := testStreamOptions(1, nil)
+	streamOptions.objectFormat = objectFormatParquet
+	streamOptions.parquet = options
+	streamOptions.registry = registry
+	object := restoreObject{Key: "rows.parquet", Target: "orders", Size: int64(len(body)), Partition: -1, StartOffset: -1}
+
+	records := make(chan *restoreRecord, 100)
+	err := streamParquetObject(context.Background(), newTestS3Client(server), testBucket, object, streamOptions, &recordSender{records: records})
+	close(records)
+	var read []*restoreRecord
+	for record := range records {
+		read = append(read, record)
+	}
+	return read, err
+}
+
+func TestStreamParquetObjectJSON(t *testing.T) {
+	name := "first"
+	created := time.Date(2020, 4, 27, 22, 30, 15, 500, time.UTC)
+	body := writeTestParquet(t, new(testParquetOrder),
+		testParquetOrder{ID: 1, Name: &name, Amount: 2.5, Created: int96Timestamp(created)},
+		testParquetOrder{ID: 2, Amount: 4, Created: int96Timestamp(created.Add(36 * time.Hour))},
+	)
+
+	records, err := streamTestParquet(t, body, parquetOptions{valueFormat: parquetValueJSON, keyColumn: "id", timestampColumn: "created"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		key       string
+		name      interface{}
+		timestamp time.Time
+	}{
+		{key: "1", name: "first", timestamp: created},
+		{key: "2", name: nil, timestamp: created.Add(36 * time.Hour)},
+	}
+	if len(records) != len(tests) {
+		t.Fatalf("read %d records, want %d", len(records), len(tests))
+	}
+	for i, test := range tests {
+		record := records[i]
+		var value map[string]interface{}
+		if err := json.Unmarshal([]byte(record.Value), &value); err != nil {
+			t.Errorf("row %d: value %s isn't JSON: %v", i+1, record.Value, err)
+			continue
+		}
+		if record.Key != test.key || value["name"] != test.name || value["amount"] == nil || record.Line != int64(i+1) {
+			t.Errorf("row %d: record is %s %s at line %d, want key %s and name %v", i+1, record.Key, record.Value, record.Line, test.key, test.name)
+		}
+		if !record.Timestamp.Equal(test.timestamp) {
+			t.Errorf("row %d: INT96 timestamp is %v, want %v", i+1, record.Timestamp, test.timestamp)
+		}
+	}
+}
+
+func TestStreamParquetObjectNested(t *testing.T) {
+	zip := int32(75001)
+	body := writeTestParquet(t, new(testParquetCustomer),
+		testParquetCustomer{ID: 1, Address: testParquetAddress{City: "Paris", Zip: &zip}},
+		testParquetCustomer{ID: 2, Address: testParquetAddress{City: "Lyon"}},
+	)
+
+	records, err := streamTestParquet(t, body, parquetOptions{valueFormat: parquetValueJSON, keyColumn: "address.city"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		key   string
+		value string
+	}{
+		{key: "Paris", value: `{"address":{"city":"Paris","zip":75001},"id":1}`},
+		{key: "Lyon", value: `{"address":{"city":"Lyon","zip":null},"id":2}`},
+	}
+	if len(records) != len(want) {
+		t.Fatalf("read %d records, want %d", len(records), len(want))
+	}
+	for i, record := range records {
+		if record.Key != want[i].key || record.Value != want[i].value {
+			t.Errorf("row %d: record is %s %s, want %s %s", i+1, record.Key, record.Value, want[i].key, want[i].value)
+		}
+	}
+
+	// Nested columns have no Avro schema
+	if _, err := streamTestParquet(t, body, parquetOptions{valueFormat: parquetValueAvro}, &schemaRecorder{}); err == nil {
+		t.Errorf("nested columns were restored as Avro records")
+	}
+}
+
+func TestStreamParquetObjectAvro(t *testing.T) {
+	name := "first"
+	created := time.Date(2020, 4, 27, 22, 30, 15, 0, time.UTC)
+	body := writeTestParquet(t, new(testParquetOrder),
+		testParquetOrder{ID: 1, Name: &name, Amount: 2.5, Created: int96Timestamp(created)},
+		testParquetOrder{ID: 2, Amount: 4, Created: int96Timestamp(created)},
+	)
+
+	registry := &schemaRecorder{}
+	records, err := streamTestParquet(t, body, parquetOptions{valueFormat: parquetValueAvro, keyColumn: "id"}, registry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if registry.subject != "orders"+subjectValueSuffix {
+		t.Errorf("schema registered under %q", registry.subject)
+	}
+	codec, err := goavro.NewCodec(registry.schema)
+	if err != nil {
+		t.Fatalf("derived schema %s: %v", registry.schema, err)
+	}
+	want, _ := goavro.NewCodec(`{"type":"record","name":"parquet_go_root","fields":[
+		{"name":"id","type":"long"},
+		{"name":"name","type":["null","string"],"default":null},
+		{"name":"amount","type":"float"},
+		{"name":"created","type":"bytes"}]}`)
+	if codec.CanonicalSchema() != want.CanonicalSchema() {
+		t.Errorf("derived schema is %s, want %s", codec.CanonicalSchema(), want.CanonicalSchema())
+	}
+
+	names := []interface{}{map[string]interface{}{"string": "first"}, nil}
+	if len(records) != len(names) {
+		t.Fatalf("read %d records, want %d", len(records), len(names))
+	}
+	for i, record := range records {
+		value := []byte(record.Value)
+		if len(value) < wireFormatHeaderSize || value[0] != wireFormatMagic || binary.BigEndian.Uint32(value[1:]) != 7 {
+			t.Errorf("row %d: value doesn't start with the wire format header of schema 7: %x", i+1, value)
+			continue
+		}
+		native, _, err := codec.NativeFromBinary(value[wireFormatHeaderSize:])
+		if err != nil {
+			t.Errorf("row %d: %v", i+1, err)
+			continue
+		}
+		fields := native.(map[string]interface{})
+		if fields["id"] != int64(i+1) || !equalJSON(fields["name"], names[i]) || len(fields["created"].([]byte)) != 12 {
+			t.Errorf("row %d: record is %v, want name %v", i+1, fields, names[i])
+		}
+		if record.Key != strconv.Itoa(i+1) {
+			t.Errorf("row %d: key is %q", i+1, record.Key)
+		}
+	}
+
+	// Avro records need a schema registry
+	if _, err := streamTestParquet(t, body, parquetOptions{valueFormat: parquetValueAvro}, nil); err == nil {
+		t.Errorf("Avro records were restored without a schema registry")
+	}
+}
+
+// equalJSON reports whether two values have the same JSON
+func equalJSON(a interface{}, b interface{}) bool {
+	dataA, _ := json.Marshal(a)
+	dataB, _ := json.Marshal(b)
+	return bytes.Equal(dataA, dataB)
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+const (
+	defaultPlanFile = "restore-plan.json"
+
+	// The record count is estimated from the first bytes of a few objects of every topic
+	planSampleObjects = 3
+	planSampleBytes   = 64 * 1024
+)
+
+// planObject is an object the restore would read
+type planObject struct {
+	Key        string `json:"key"`
+	Size       int64  `json:"size"`
+	Partition  int32  `json:"partition"`
+	KeysKey    string `json:"keys_key,omitempty"`
+	HeadersKey string `json:"headers_key,omitempty"`
+}
+
+// planPeriod is a layout period of a topic, and the objects listed under its prefix
+type planPeriod struct {
+	Period  time.Time    `json:"period"`
+	Prefix  string       `json:"prefix"`
+	Objects []planObject `json:"objects"`
+	Bytes   int64        `json:"bytes"`
+}
+
+// planTopic is what the restore would do for one source topic
+type planTopic struct {
+	Source           string       `json:"source_topic"`
+	Target           string       `json:"target_topic"`
+	Periods          []planPeriod `json:"periods"`
+	Objects          int          `json:"objects"`
+	Bytes            int64        `json:"bytes"`
+	EstimatedRecords int64        `json:"estimated_records"`
+}
+
+// restorePlan is the machine-readable report of a dry run
+type restorePlan struct {
+	Bucket           string      `json:"bucket"`
+	Layout           string      `json:"layout"`
+	Start            time.Time   `json:"start"`
+	End              time.Time   `json:"end"`
+	Topics           []planTopic `json:"topics"`
+	Objects          int         `json:"objects"`
+	Bytes            int64       `json:"bytes"`
+	EstimatedRecords int64       `json:"estimated_records"`
+}
+
+// buildRestorePlan describes the restore of the listed topics, without writing anything to Kafka
+func buildRestorePlan(s3Client *s3.S3, bucket string, layout *keyLayout, window restoreWindow, options streamOptions, restores []*topicRestore) *restorePlan {
+	plan := &restorePlan{Bucket: bucket, Layout: layout.template, Start: window.start, End: window.end}
+
+	for _, restore := range restores {
+		topic := planTopic{Source: restore.Source, Target: restore.Target}
+		// periods holds the index of every period in topic.Periods
+		periods := make(map[time.Time]int)
+		addPeriod := func(period time.Time) {
+			periods[period] = len(topic.Periods)
+			topic.Periods = append(topic.Periods, planPeriod{Period: period, Prefix: layout.prefix(restore.Source, period), Objects: []planObject{}})
+		}
+		for _, period := range layout.periods(window.start, window.end) {
+			addPeriod(period)
+		}
+
+		for _, object := range restore.Objects {
+			// The objects are listed by the periods of the window, but a period the window misses gets its own entry
+			if _, ok := periods[object.Period]; !ok {
+				addPeriod(object.Period)
+			}
+			period := &topic.Periods[periods[object.Period]]
+			period.Objects = append(period.Objects, planObject{
+				Key:        object.Key,
+				Size:       object.Size,
+				Partition:  object.Partition,
+				KeysKey:    object.KeysKey,
+				HeadersKey: object.HeadersKey,
+			})
+			period.Bytes += object.Size
+			topic.Objects++
+			topic.Bytes += object.Size
+		}
+
+		if recordSize, ok := sampleRecordSize(s3Client, bucket, restore.Objects, options); ok {
+			topic.EstimatedRecords = int64(float64(topic.Bytes) / recordSize)
+		}
+
+		plan.Topics = append(plan.Topics, topic)
+		plan.Objects += topic.Objects
+		plan.Bytes += topic.Bytes
+		plan.EstimatedRecords += topic.EstimatedRecords
+	}
+	return plan
+}
+
+// sampleRecordSize returns the average stored size of a record in the first bytes of a few objects spread
+// over the list. Compressed samples are decompressed as far as they go, and their compressed size is used.
+// Avro samples count the records of their whole blocks, and Parquet objects the rows of their footer.
+func sampleRecordSize(s3Client *s3.S3, bucket string, objects []restoreObject, options streamOptions) (float64, bool) {
+	var sampledBytes, sampledRecords int64
+	for i := 0; i < planSampleObjects && i < len(objects); i++ {
+		object := objects[i*len(objects)/planSampleObjects]
+		if detectObjectFormat(object.Key, options.objectFormat) == objectFormatParquet {
+			// The footer of a Parquet object has its exact number of rows
+			rows, err := countParquetRows(s3Client, bucket, object.Key, object.Size)
+			if err != nil {
+				logger.warning(componentS3, fmt.Sprintf("Error sampling %s: %v", object.Key, err))
+				continue
+			}
+			if rows > 0 {
+				sampledBytes += object.Size
+				sampledRecords += rows
+			}
+			continue
+		}
+		output, err := s3Client.GetObject(&s3.GetObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(object.Key),
+			Range:  aws.String(fmt.Sprintf("bytes=0-%d", planSampleBytes-1)),
+		})
+		if err != nil {
+			logger.warning(componentS3, fmt.Sprintf("Error sampling %s: %v", object.Key, err))
+			continue
+		}
+		sample, err := ioutil.ReadAll(io.LimitReader(output.Body, planSampleBytes))
+		output.Body.Close()
+		if err != nil {
+			continue
+		}
+
+		// A truncated compressed sample ends with an error, after the lines it could decompress
+		complete := int64(len(sample)) < planSampleBytes
+		decompressed, err := newDecompressingReader(bytes.NewReader(sample), object.Key, options.compression)
+		if err != nil {
+			continue
+		}
+		var records int64
+		if detectObjectFormat(object.Key, options.objectFormat) == objectFormatAvro {
+			records = countAvroRecords(decompressed)
+		} else {
+			lines, _ := ioutil.ReadAll(decompressed)
+
+			// Only whole lines are counted, unless the object was read completely
+			records = int64(bytes.Count(lines, []byte{'\n'}))
+			if complete && len(lines) > 0 && lines[len(lines)-1] != '\n' {
+				records++
+			}
+		}
+		decompressed.Close()
+		if records == 0 {
+			continue
+		}
+		sampledBytes += int64(len(sample))
+		sampledRecords += records
+	}
+
+	if sampledRecords == 0 {
+		return 0, false
+	}
+	return float64(sampledBytes) / float64(sampledRecords), true
+}
+
+// writeRestorePlan prints the plan for humans, and writes it as JSON into planFile
+func writeRestorePlan(plan *restorePlan, output io.Writer, planFile string) error {
+	writer := tabwriter.NewWriter(output, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(writer, "Restore plan (dry run, nothing is written to Kafka)\n")
+	fmt.Fprintf(writer, "Bucket:\t%s\n", plan.Bucket)
+	fmt.Fprintf(writer, "Layout:\t%s\n", plan.Layout)
+	fmt.Fprintf(writer, "Window:\t%s - %s\n", plan.Start.Format(time.RFC3339), plan.End.Format(time.RFC3339))
+
+	for _, topic := range plan.Topics {
+		fmt.Fprintf(writer, "\nTopic %s -> %s\n", topic.Source, topic.Target)
+		for _, period := range topic.Periods {
+			fmt.Fprintf(writer, "  %s\t%s\t%d objects\t%d bytes\n", period.Period.Format(time.RFC3339), period.Prefix, len(period.Objects), period.Bytes)
+			for _, object := range period.Objects {
+				fmt.Fprintf(writer, "    %s\t%d bytes\n", object.Key, object.Size)
+			}
+		}
+		fmt.Fprintf(writer, "  Total:\t%d objects\t%d bytes\t~%d records\n", topic.Objects, topic.Bytes, topic.EstimatedRecords)
+	}
+	fmt.Fprintf(writer, "\nTotal:\t%d objects\t%d bytes\t~%d records\n", plan.Objects, plan.Bytes, plan.EstimatedRecords)
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(planFile, data, 0644)
+}
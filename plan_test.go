@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildRestorePlan(t *testing.T) {
+	day1 := time.Date(2020, 4, 27, 0, 0, 0, 0, time.UTC)
+	day2 := day1.AddDate(0, 0, 1)
+	// Every line is 10 bytes
+	objects := map[string]*fakeS3Object{
+		"topics/orders/year=2020/month=04/day=27/orders+0+0000000000.json": {body: strings.Repeat("order-001\n", 30)},
+		"topics/orders/year=2020/month=04/day=27/orders+1+0000000000.json": {body: strings.Repeat("order-002\n", 10)},
+		"topics/orders/year=2020/month=04/day=28/orders+0+0000000030.json": {body: strings.Repeat("order-003\n", 20)},
+		"topics/users/year=2020/month=04/day=26/users+0+0000000000.json":   {body: strings.Repeat("user-0001\n", 5)},
+	}
+	server := httptest.NewServer(newFakeS3(objects))
+	defer server.Close()
+
+	layout, err := newKeyLayout(defaultKeyLayout, defaultTopicsDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	object := func(key string, period time.Time, partition int32) restoreObject {
+		return restoreObject{Key: key, Topic: "orders", Size: int64(len(objects[key].body)), Period: period, Partition: partition, StartOffset: -1}
+	}
+	restores := []*topicRestore{
+		{Source: "orders", Target: "orders-restore", Objects: []restoreObject{
+			object("topics/orders/year=2020/month=04/day=27/orders+0+0000000000.json", day1, 0),
+			object("topics/orders/year=2020/month=04/day=27/orders+1+0000000000.json", day1, 1),
+			object("topics/orders/year=2020/month=04/day=28/orders+0+0000000030.json", day2, 0),
+		}},
+		// An object of a period outside the window still has its entry
+		{Source: "users", Target: "users-restore", Objects: []restoreObject{
+			object("topics/users/year=2020/month=04/day=26/users+0+0000000000.json", day1.AddDate(0, 0, -1), 0),
+		}},
+		{Source: "empty", Target: "empty-restore"},
+	}
+
+	window := restoreWindow{start: day1.Add(time.Hour), end: day2.Add(time.Hour)}
+	plan := buildRestorePlan(newTestS3Client(server), testBucket, layout, window, testStreamOptions(1, nil), restores)
+
+	if plan.Objects != 4 || plan.Bytes != 650 || plan.EstimatedRecords != 65 {
+		t.Errorf("plan totals are %d objects, %d bytes and %d records, want 4, 650 and 65", plan.Objects, plan.Bytes, plan.EstimatedRecords)
+	}
+	tests := []struct {
+		objects int
+		bytes   int64
+		records int64
+		periods []int
+	}{
+		{objects: 3, bytes: 600, records: 60, periods: []int{2, 1}},
+		{objects: 1, bytes: 50, records: 5, periods: []int{0, 0, 1}},
+		{periods: []int{0, 0}},
+	}
+	if len(plan.Topics) != len(tests) {
+		t.Fatalf("plan has %d topics, want %d", len(plan.Topics), len(tests))
+	}
+	for i, test := range tests {
+		topic := plan.Topics[i]
+		var periods []int
+		for _, period := range topic.Periods {
+			periods = append(periods, len(period.Objects))
+		}
+		if topic.Objects != test.objects || topic.Bytes != test.bytes || topic.EstimatedRecords != test.records || !reflect.DeepEqual(periods, test.periods) {
+			t.Errorf("topic %s has %d objects in periods %v, %d bytes and %d records, want %d in %v, %d and %d",
+				topic.Source, topic.Objects, periods, topic.Bytes, topic.EstimatedRecords, test.objects, test.periods, test.bytes, test.records)
+		}
+	}
+	if prefix := plan.Topics[0].Periods[1].Prefix; prefix != "topics/orders/year=2020/month=04/day=28/" {
+		t.Errorf("prefix of the second period is %s", prefix)
+	}
+
+	dir, err := ioutil.TempDir("", "plan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	planFile := filepath.Join(dir, "plan.json")
+	var output strings.Builder
+	if err := writeRestorePlan(plan, &output, planFile); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(output.String(), "Topic orders -> orders-restore") || !strings.Contains(output.String(), "650 bytes") {
+		t.Errorf("printed plan is\n%s", output.String())
+	}
+
+	data, err := ioutil.ReadFile(planFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var written restorePlan
+	if err := json.Unmarshal(data, &written); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(&written, plan) {
+		t.Errorf("plan file is\n%s", data)
+	}
+	var fields map[string]interface{}
+	json.Unmarshal(data, &fields)
+	topic := fields["topics"].([]interface{})[0].(map[string]interface{})
+	if fields["estimated_records"] != float64(65) || topic["source_topic"] != "orders" || topic["target_topic"] != "orders-restore" {
+		t.Errorf("plan file has the fields %v", fields)
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// throughputLimiter holds the restore under a ceiling of messages per second and bytes per second.
+// A ceiling of 0 is unlimited, and both can be changed while the restore runs.
+type throughputLimiter struct {
+	messages *rate.Limiter
+	bytes    *rate.Limiter
+	// maxRecordBytes is the smallest bytes burst, so that a single record always fits
+	maxRecordBytes int
+
+	// mutex orders the changes of the limits with the reservations of the waiting records
+	mutex sync.Mutex
+	// changed is closed when the limits change, to wake up the waiting records
+	changed chan struct{}
+}
+
+// throughputLimits are the ceilings of the limiter, as read and written by the admin endpoint
+type throughputLimits struct {
+	MessagesPerSecond float64 `json:"messages_per_second"`
+	BytesPerSecond    float64 `json:"bytes_per_second"`
+}
+
+// newThroughputLimiter returns a limiter with the given ceilings, which lets a second of traffic through right away
+func newThroughputLimiter(limits throughputLimits, maxRecordBytes int) (*throughputLimiter, error) {
+	if err := limits.validate(); err != nil {
+		return nil, err
+	}
+	limiter := &throughputLimiter{maxRecordBytes: maxRecordBytes, changed: make(chan struct{})}
+	limiter.messages = rate.NewLimiter(rateLimit(limits.MessagesPerSecond), limiter.messagesBurst(limits))
+	limiter.bytes = rate.NewLimiter(rateLimit(limits.BytesPerSecond), limiter.bytesBurst(limits))
+	return limiter, nil
+}
+
+// setLimits changes the ceilings. The bursts are a second of traffic, so the limits hold on average
+// over every second.
+func (l *throughputLimiter) setLimits(limits throughputLimits) error {
+	if err := limits.validate(); err != nil {
+		return err
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.messages.SetLimit(rateLimit(limits.MessagesPerSecond))
+	l.messages.SetBurst(l.messagesBurst(limits))
+	l.bytes.SetLimit(rateLimit(limits.BytesPerSecond))
+	l.bytes.SetBurst(l.bytesBurst(limits))
+	close(l.changed)
+	l.changed = make(chan struct{})
+	return nil
+}
+
+func (l *throughputLimiter) messagesBurst(limits throughputLimits) int {
+	return int(math.Max(1, math.Ceil(limits.MessagesPerSecond)))
+}
+
+func (l *throughputLimiter) bytesBurst(limits throughputLimits) int {
+	return int(math.Max(float64(l.maxRecordBytes), math.Ceil(limits.BytesPerSecond)))
+}
+
+// validate checks that the ceilings are positive
+func (limits throughputLimits) validate() error {
+	if limits.MessagesPerSecond < 0 || limits.BytesPerSecond < 0 {
+		return fmt.Errorf("invalid throughput limits %+v, they must be positive, or 0 for unlimited", limits)
+	}
+	return nil
+}
+
+// limits returns the current ceilings
+func (l *throughputLimiter) limits() throughputLimits {
+	return throughputLimits{
+		MessagesPerSecond: limitValue(l.messages.Limit()),
+		BytesPerSecond:    limitValue(l.bytes.Limit()),
+	}
+}
+
+// wait blocks until a record of size bytes is allowed into the producer, or until ctx is cancelled.
+// A record waiting when the limits change gives back its reservation, and waits again under the new limits.
+func (l *throughputLimiter) wait(ctx context.Context, size int) error {
+	for {
+		l.mutex.Lock()
+		tokens := size
+		if tokens > l.bytes.Burst() {
+			tokens = l.bytes.Burst()
+		}
+		now := time.Now()
+		messages := l.messages.ReserveN(now, 1)
+		bytes := l.bytes.ReserveN(now, tokens)
+		changed := l.changed
+		l.mutex.Unlock()
+
+		delay := messages.DelayFrom(now)
+		if bytesDelay := bytes.DelayFrom(now); bytesDelay > delay {
+			delay = bytesDelay
+		}
+		if delay == 0 {
+			return nil
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+			return nil
+		case <-ctx.Done():
+			timer.Stop()
+			messages.Cancel()
+			bytes.Cancel()
+			return ctx.Err()
+		case <-changed:
+			timer.Stop()
+			messages.Cancel()
+			bytes.Cancel()
+		}
+	}
+}
+
+func rateLimit(perSecond float64) rate.Limit {
+	if perSecond == 0 {
+		return rate.Inf
+	}
+	return rate.Limit(perSecond)
+}
+
+func limitValue(limit rate.Limit) float64 {
+	if limit == rate.Inf {
+		return 0
+	}
+	return float64(limit)
+}
+
+// recordSize is the number of bytes a record produces, counting its key, value and headers
+func recordSize(record *restoreRecord) int {
+	size := len(record.Key) + len(record.Value)
+	for _, header := range record.Headers {
+		size += len(header.Key) + len(header.Value)
+	}
+	return size
+}
+
+// ServeHTTP returns the current limits on GET, and changes them on PUT or POST. The new limits are read
+// from the messages_per_second and bytes_per_second query parameters, or else from a JSON body.
+// Limits left out keep their value.
+func (l *throughputLimiter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodPost:
+		limits := l.limits()
+		if err := readThroughputLimits(r, &limits); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := l.setLimits(limits); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		logger.info(componentMain, fmt.Sprintf("Throughput limits changed to %v messages/s and %v bytes/s (0 is unlimited)",
+			limits.MessagesPerSecond, limits.BytesPerSecond))
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(l.limits())
+}
+
+// readThroughputLimits overrides limits with the ones of a request
+func readThroughputLimits(r *http.Request, limits *throughputLimits) error {
+	query := r.URL.Query()
+	if len(query) == 0 {
+		if err := json.NewDecoder(r.Body).Decode(limits); err != nil {
+			return fmt.Errorf("invalid throughput limits: %v", err)
+		}
+		return nil
+	}
+
+	for name, limit := range map[string]*float64{
+		"messages_per_second": &limits.MessagesPerSecond,
+		"bytes_per_second":    &limits.BytesPerSecond,
+	} {
+		if value := query.Get(name); value != "" {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return fmt.Errorf("invalid %s %q", name, value)
+			}
+			*limit = parsed
+		}
+	}
+	return nil
+}
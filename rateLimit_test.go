@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// timeWait returns how long a wait of the limiter took
+func timeWait(t *testing.T, limiter *throughputLimiter, size int) time.Duration {
+	start := time.Now()
+	if err := limiter.wait(context.Background(), size); err != nil {
+		t.Fatal(err)
+	}
+	return time.Since(start)
+}
+
+func TestThroughputLimiterWait(t *testing.T) {
+	tests := []struct {
+		name   string
+		limits throughputLimits
+		// sizes of the records that pass right away, and of the record that waits for delay
+		sizes []int
+		size  int
+		delay time.Duration
+	}{
+		{name: "unlimited", sizes: []int{1, 1000000, 5}, size: 1},
+		{name: "messages", limits: throughputLimits{MessagesPerSecond: 10}, sizes: []int{1, 1, 1, 1, 1, 1, 1, 1, 1, 1}, size: 1, delay: 100 * time.Millisecond},
+		{name: "bytes", limits: throughputLimits{BytesPerSecond: 200}, sizes: []int{150, 50}, size: 20, delay: 100 * time.Millisecond},
+		// A record larger than a second of traffic only takes the burst
+		{name: "record over the burst", limits: throughputLimits{BytesPerSecond: 200}, sizes: []int{5000}, size: 20, delay: 100 * time.Millisecond},
+	}
+
+	for _, test := range tests {
+		limiter, err := newThroughputLimiter(test.limits, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, size := range test.sizes {
+			if waited := timeWait(t, limiter, size); waited > 20*time.Millisecond {
+				t.Errorf("%s: a record of %d bytes waited %v within the burst", test.name, size, waited)
+			}
+		}
+		if waited := timeWait(t, limiter, test.size); waited < test.delay/2 || waited > test.delay*3+20*time.Millisecond {
+			t.Errorf("%s: the record over the burst waited %v, want about %v", test.name, waited, test.delay)
+		}
+	}
+}
+
+func TestThroughputLimiterWaitCancelled(t *testing.T) {
+	limiter, err := newThroughputLimiter(throughputLimits{MessagesPerSecond: 1}, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	timeWait(t, limiter, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := limiter.wait(ctx, 1); err != context.DeadlineExceeded || time.Since(start) > 500*time.Millisecond {
+		t.Errorf("wait returned %v after %v, want the error of ctx right after it's done", err, time.Since(start))
+	}
+}
+
+func TestThroughputLimiterChangeResumesWait(t *testing.T) {
+	limiter, err := newThroughputLimiter(throughputLimits{MessagesPerSecond: 1}, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(limiter)
+	defer server.Close()
+	timeWait(t, limiter, 1)
+
+	// The second record waits a second at 1 message/s, until the limit is lifted
+	waited := make(chan time.Duration, 1)
+	go func() {
+		waited <- timeWait(t, limiter, 1)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	response, err := http.Post(server.URL+"?messages_per_second=0", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+
+	select {
+	case duration := <-waited:
+		if duration > 500*time.Millisecond {
+			t.Errorf("the wait took %v after the limit was lifted", duration)
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("the wait didn't resume under the new limits")
+	}
+	if limits := limiter.limits(); limits.MessagesPerSecond != 0 {
+		t.Errorf("limits are %+v after the change, want unlimited messages", limits)
+	}
+}
+
+func TestThroughputLimiterHandler(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		query  string
+		body   string
+		status int
+		limits throughputLimits
+	}{
+		{name: "get", method: http.MethodGet, status: http.StatusOK, limits: throughputLimits{MessagesPerSecond: 100, BytesPerSecond: 5000}},
+		{name: "put query", method: http.MethodPut, query: "?messages_per_second=20&bytes_per_second=800", status: http.StatusOK, limits: throughputLimits{MessagesPerSecond: 20, BytesPerSecond: 800}},
+		{name: "put one limit", method: http.MethodPut, query: "?bytes_per_second=0", status: http.StatusOK, limits: throughputLimits{MessagesPerSecond: 100}},
+		{name: "post body", method: http.MethodPost, body: `{"messages_per_second": 7.5}`, status: http.StatusOK, limits: throughputLimits{MessagesPerSecond: 7.5, BytesPerSecond: 5000}},
+		{name: "bad query", method: http.MethodPut, query: "?messages_per_second=fast", status: http.StatusBadRequest},
+		{name: "bad body", method: http.MethodPost, body: `{"messages_per_second": "fast"}`, status: http.StatusBadRequest},
+		{name: "negative limit", method: http.MethodPut, query: "?bytes_per_second=-1", status: http.StatusBadRequest},
+		{name: "delete", method: http.MethodDelete, status: http.StatusMethodNotAllowed},
+	}
+
+	for _, test := range tests {
+		limiter, err := newThroughputLimiter(throughputLimits{MessagesPerSecond: 100, BytesPerSecond: 5000}, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		recorder := httptest.NewRecorder()
+		limiter.ServeHTTP(recorder, httptest.NewRequest(test.method, adminRatePath+test.query, strings.NewReader(test.body)))
+		if recorder.Code != test.status {
+			t.Errorf("%s: status is %d, want %d", test.name, recorder.Code, test.status)
+			continue
+		}
+		if test.status != http.StatusOK {
+			if limits := limiter.limits(); limits != (throughputLimits{MessagesPerSecond: 100, BytesPerSecond: 5000}) {
+				t.Errorf("%s: limits changed to %+v on a failed request", test.name, limits)
+			}
+			continue
+		}
+		var limits throughputLimits
+		if err := json.NewDecoder(recorder.Body).Decode(&limits); err != nil || limits != test.limits {
+			t.Errorf("%s: response is %+v, %v, want %+v", test.name, limits, err, test.limits)
+		}
+		if limits := limiter.limits(); limits != test.limits {
+			t.Errorf("%s: limits are %+v, want %+v", test.name, limits, test.limits)
+		}
+	}
+}
+
+func TestRecordSize(t *testing.T) {
+	record := &restoreRecord{KafkaMessage: KafkaMessage{Key: "key", Value: "value", Headers: []KafkaHeader{{Key: "h", Value: "vv"}}}}
+	if size := recordSize(record); size != 11 {
+		t.Errorf("recordSize = %d, want 11", size)
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+const (
+	// objectFormatAuto detects the format of every object from its extension
+	objectFormatAuto = "auto"
+	// objectFormatLines objects hold newline-delimited records, decoded according to the record format
+	objectFormatLines = "lines"
+	// objectFormatAvro objects are Avro object container files, as written by the AvroFormat of Kafka Connect
+	objectFormatAvro = "avro"
+	// objectFormatParquet objects are Parquet files, as written by the ParquetFormat of Kafka Connect
+	objectFormatParquet = "parquet"
+
+	avroExtension    = ".avro"
+	parquetExtension = ".parquet"
+
+	// recordFormatValue restores every line as the record value
+	recordFormatValue = "value"
+	// recordFormatEnvelope restores every line as a JSON envelope with the key, value, headers and timestamp
+	recordFormatEnvelope = "envelope"
+
+	// Kafka Connect writes keys and headers into side objects next to the values when
+	// store.kafka.keys and store.kafka.headers are enabled
+	sideObjectKeys    = ".keys."
+	sideObjectHeaders = ".headers."
+
+	// envelopeEncodingBase64 marks an envelope whose key and value are base64, such as the dead letters of
+	// binary records
+	envelopeEncodingBase64 = "base64"
+)
+
+// validateObjectFormat checks a configured object format
+func validateObjectFormat(format string) error {
+	switch format {
+	case objectFormatAuto, objectFormatLines, objectFormatAvro, objectFormatParquet:
+		return nil
+	}
+	return fmt.Errorf("unknown object format %q, expected one of %s, %s, %s or %s",
+		format, objectFormatAuto, objectFormatLines, objectFormatAvro, objectFormatParquet)
+}
+
+// detectObjectFormat returns the format of an object. With the auto format it's read from the extension of the
+// object, ignoring the extension of its compression, and objects of unknown extensions hold lines.
+func detectObjectFormat(key string, format string) string {
+	if format != objectFormatAuto && format != "" {
+		return format
+	}
+	extension := strings.ToLower(path.Ext(key))
+	if _, ok := compressionExtensions[extension]; ok {
+		extension = strings.ToLower(path.Ext(strings.TrimSuffix(key, path.Ext(key))))
+	}
+	switch extension {
+	case avroExtension:
+		return objectFormatAvro
+	case parquetExtension:
+		return objectFormatParquet
+	}
+	return objectFormatLines
+}
+
+// envelopeFields are the names of the envelope fields
+type envelopeFields struct {
+	key       string
+	value     string
+	headers   string
+	timestamp string
+	// encoding is the field telling how the key and value are encoded, which are text when it's missing
+	encoding string
+}
+
+// recordDecoder rebuilds full records out of the lines of the backup objects
+type recordDecoder struct {
+	format string
+	fields envelopeFields
+	// timestamps parses the envelope timestamps, using the configured record timestamp layout
+	timestamps *timestampExtractor
+}
+
+// newRecordDecoder validates the record format and returns its decoder
+func newRecordDecoder(format string, fields envelopeFields, timestampLayout string) (*recordDecoder, error) {
+	switch format {
+	case "":
+		format = recordFormatValue
+	case recordFormatValue, recordFormatEnvelope:
+	default:
+		return nil, fmt.Errorf("unknown record format %q, expected %s or %s", format, recordFormatValue, recordFormatEnvelope)
+	}
+	return &recordDecoder{format: format, fields: fields, timestamps: &timestampExtractor{layout: timestampLayout}}, nil
+}
+
+// decode fills the record from a value line, and from the matching lines of the key and headers
+// side objects, which are nil when the object has none
+func (d *recordDecoder) decode(line []byte, keyLine []byte, headersLine []byte, record *restoreRecord) error {
+	if d.format == recordFormatEnvelope {
+		if err := d.decodeEnvelope(line, record); err != nil {
+			return err
+		}
+	} else {
+		record.Value = string(line)
+	}
+
+	if keyLine != nil {
+		record.Key = jsonText(keyLine)
+	}
+	if headersLine != nil {
+		headers, err := parseHeaders(headersLine)
+		if err != nil {
+			return fmt.Errorf("invalid headers: %v", err)
+		}
+		record.Headers = headers
+	}
+	return nil
+}
+
+// decodeEnvelope reads the key, value, headers and timestamp of a JSON envelope
+func (d *recordDecoder) decodeEnvelope(line []byte, record *restoreRecord) error {
+	var envelope map[string]json.RawMessage
+	if err := json.Unmarshal(line, &envelope); err != nil {
+		return fmt.Errorf("invalid envelope: %v", err)
+	}
+
+	encoding := ""
+	if raw, ok := envelope[d.fields.encoding]; ok {
+		encoding = jsonText(raw)
+	}
+	switch encoding {
+	case "", envelopeEncodingBase64:
+	default:
+		return fmt.Errorf("unknown envelope encoding %q, expected %s", encoding, envelopeEncodingBase64)
+	}
+	if raw, ok := envelope[d.fields.value]; ok {
+		value, err := decodeEnvelopeText(raw, encoding)
+		if err != nil {
+			return fmt.Errorf("invalid envelope value: %v", err)
+		}
+		record.Value = value
+		record.tombstone = isJSONNull(raw)
+	}
+	if raw, ok := envelope[d.fields.key]; ok {
+		key, err := decodeEnvelopeText(raw, encoding)
+		if err != nil {
+			return fmt.Errorf("invalid envelope key: %v", err)
+		}
+		record.Key = key
+	}
+	if raw, ok := envelope[d.fields.headers]; ok {
+		headers, err := parseHeaders(raw)
+		if err != nil {
+			return fmt.Errorf("invalid envelope headers: %v", err)
+		}
+		record.Headers = headers
+	}
+	if raw, ok := envelope[d.fields.timestamp]; ok && !isJSONNull(raw) {
+		timestamp, ok := d.timestamps.parse(bytes.TrimSpace(raw))
+		if !ok {
+			return fmt.Errorf("invalid envelope timestamp %s", raw)
+		}
+		record.Timestamp = timestamp
+	}
+	return nil
+}
+
+// decodeEnvelopeText returns the text of an envelope key or value, decoding it when it's base64
+func decodeEnvelopeText(raw []byte, encoding string) (string, error) {
+	text := jsonText(raw)
+	if encoding != envelopeEncodingBase64 {
+		return text, nil
+	}
+	data, err := base64.StdEncoding.DecodeString(text)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// jsonText returns the text of a JSON string, nothing for null, and the raw JSON of any other value.
+// Lines that aren't JSON at all are returned as they are.
+func jsonText(raw []byte) string {
+	raw = bytes.TrimSpace(raw)
+	if isJSONNull(raw) {
+		return ""
+	}
+	if len(raw) > 0 && raw[0] == '"' {
+		var text string
+		if err := json.Unmarshal(raw, &text); err == nil {
+			return text
+		}
+	}
+	return string(raw)
+}
+
+func isJSONNull(raw []byte) bool {
+	return string(bytes.TrimSpace(raw)) == "null"
+}
+
+// parseHeaders reads headers written either as a JSON object, or as an array of key and value pairs
+func parseHeaders(raw []byte) ([]KafkaHeader, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || isJSONNull(raw) {
+		return nil, nil
+	}
+
+	if raw[0] == '[' {
+		var pairs []struct {
+			Key   string          `json:"key"`
+			Value json.RawMessage `json:"value"`
+		}
+		if err := json.Unmarshal(raw, &pairs); err != nil {
+			return nil, err
+		}
+		headers := make([]KafkaHeader, 0, len(pairs))
+		for _, pair := range pairs {
+			headers = append(headers, KafkaHeader{Key: pair.Key, Value: jsonText(pair.Value)})
+		}
+		return headers, nil
+	}
+
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &object); err != nil {
+		return nil, err
+	}
+	headers := make([]KafkaHeader, 0, len(object))
+	for key, value := range object {
+		headers = append(headers, KafkaHeader{Key: key, Value: jsonText(value)})
+	}
+	sort.Slice(headers, func(i, j int) bool { return headers[i].Key < headers[j].Key })
+	return headers, nil
+}
+
+// isSideObject reports whether an object holds the keys or headers of another object
+func isSideObject(key string) bool {
+	extension := objectNameExtension(path.Base(key))
+	return strings.HasPrefix(extension, sideObjectKeys) || strings.HasPrefix(extension, sideObjectHeaders)
+}
+
+// sideObjectKey returns the key of the side object of a value object, such as
+// my.topic+0+0000000000.keys.json for my.topic+0+0000000000.json
+func sideObjectKey(key string, side string) string {
+	dir, name := path.Split(key)
+	extension := objectNameExtension(name)
+	if extension == "" {
+		return key + strings.TrimSuffix(side, ".")
+	}
+	return dir + strings.TrimSuffix(name, extension) + side + extension[1:]
+}
+
+// objectNameExtension returns the extensions of an object name, such as .keys.json. The extensions of the
+// <topic>+<partition>+<startOffset> names of Kafka Connect start after the offset, since topics may hold dots.
+func objectNameExtension(name string) string {
+	if match := connectObjectNamePattern.FindStringSubmatch(name); match != nil {
+		return match[4]
+	}
+	if dot := strings.Index(name, "."); dot >= 0 {
+		return name[dot:]
+	}
+	return ""
+}
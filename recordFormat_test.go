@@ -0,0 +1,159 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSideObjectKey(t *testing.T) {
+	tests := []struct {
+		key     string
+		keys    string
+		headers string
+	}{
+		{
+			key:     "topics/orders/year=2020/orders+0+0000000000.json",
+			keys:    "topics/orders/year=2020/orders+0+0000000000.keys.json",
+			headers: "topics/orders/year=2020/orders+0+0000000000.headers.json",
+		},
+		{
+			key:     "topics/my.topic/year=2020/my.topic+3+0000000100.json.gz",
+			keys:    "topics/my.topic/year=2020/my.topic+3+0000000100.keys.json.gz",
+			headers: "topics/my.topic/year=2020/my.topic+3+0000000100.headers.json.gz",
+		},
+		{
+			key:     "backup/v1.2/records.json",
+			keys:    "backup/v1.2/records.keys.json",
+			headers: "backup/v1.2/records.headers.json",
+		},
+		{
+			key:     "topics/orders/orders+0+0000000000",
+			keys:    "topics/orders/orders+0+0000000000.keys",
+			headers: "topics/orders/orders+0+0000000000.headers",
+		},
+	}
+
+	for _, test := range tests {
+		if keys := sideObjectKey(test.key, sideObjectKeys); keys != test.keys {
+			t.Errorf("keys of %q is %q, want %q", test.key, keys, test.keys)
+		}
+		if headers := sideObjectKey(test.key, sideObjectHeaders); headers != test.headers {
+			t.Errorf("headers of %q is %q, want %q", test.key, headers, test.headers)
+		}
+		if isSideObject(test.key) {
+			t.Errorf("%q is a side object", test.key)
+		}
+	}
+}
+
+func TestIsSideObject(t *testing.T) {
+	tests := []struct {
+		key  string
+		side bool
+	}{
+		{key: "topics/orders/orders+0+0000000000.keys.json", side: true},
+		{key: "topics/orders/orders+0+0000000000.headers.json.gz", side: true},
+		{key: "topics/my.keys.topic/my.keys.topic+0+0000000000.json", side: false},
+		{key: "topics/orders/orders+0+0000000000.json", side: false},
+	}
+
+	for _, test := range tests {
+		if side := isSideObject(test.key); side != test.side {
+			t.Errorf("isSideObject(%q) = %v, want %v", test.key, side, test.side)
+		}
+	}
+}
+
+func TestRecordDecoderEnvelope(t *testing.T) {
+	fields := envelopeFields{key: "key", value: "value", headers: "headers", timestamp: "timestamp", encoding: "encoding"}
+	decoder, err := newRecordDecoder(recordFormatEnvelope, fields, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		line      string
+		key       string
+		value     string
+		tombstone bool
+		headers   []KafkaHeader
+		timestamp time.Time
+		fails     bool
+	}{
+		{
+			line:      `{"key":"k1","value":{"id":1},"headers":{"b":"2","a":"1"},"timestamp":"2020-04-27T10:00:00Z"}`,
+			key:       "k1",
+			value:     `{"id":1}`,
+			headers:   []KafkaHeader{{Key: "a", Value: "1"}, {Key: "b", Value: "2"}},
+			timestamp: time.Date(2020, 4, 27, 10, 0, 0, 0, time.UTC),
+		},
+		{
+			line:    `{"key":null,"value":"text","headers":[{"key":"a","value":"1"}]}`,
+			value:   "text",
+			headers: []KafkaHeader{{Key: "a", Value: "1"}},
+		},
+		{line: `{"key":"k1","value":null}`, key: "k1", tombstone: true},
+		{line: `{"key":"k1","value":""}`, key: "k1"},
+		{line: `{"key":"azE=","value":"AP8=","encoding":"base64"}`, key: "k1", value: "\x00\xff"},
+		{line: `{"key":"k1","value":"not base64","encoding":"base64"}`, fails: true},
+		{line: `not json`, fails: true},
+		{line: `{"value":"v","timestamp":"yesterday"}`, fails: true},
+	}
+
+	for _, test := range tests {
+		record := &restoreRecord{}
+		err := decoder.decode([]byte(test.line), nil, nil, record)
+		if test.fails {
+			if err == nil {
+				t.Errorf("decode(%s) succeeded, want an error", test.line)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("decode(%s): %v", test.line, err)
+			continue
+		}
+		if record.Key != test.key || record.Value != test.value || record.tombstone != test.tombstone {
+			t.Errorf("decode(%s) = key %q, value %q, tombstone %v, want %q, %q, %v",
+				test.line, record.Key, record.Value, record.tombstone, test.key, test.value, test.tombstone)
+		}
+		if len(record.Headers) != len(test.headers) {
+			t.Errorf("decode(%s) headers are %v, want %v", test.line, record.Headers, test.headers)
+		} else {
+			for i := range record.Headers {
+				if record.Headers[i] != test.headers[i] {
+					t.Errorf("decode(%s) headers are %v, want %v", test.line, record.Headers, test.headers)
+					break
+				}
+			}
+		}
+		if !record.Timestamp.Equal(test.timestamp) {
+			t.Errorf("decode(%s) timestamp is %v, want %v", test.line, record.Timestamp, test.timestamp)
+		}
+	}
+}
+
+func TestRecordDecoderSideLines(t *testing.T) {
+	decoder, err := newRecordDecoder(recordFormatValue, envelopeFields{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	record := &restoreRecord{}
+	err = decoder.decode([]byte(`{"id":1}`), []byte(`"k1"`), []byte(`[{"key":"a","value":"1"}]`), record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Key != "k1" || record.Value != `{"id":1}` || len(record.Headers) != 1 || record.Headers[0] != (KafkaHeader{Key: "a", Value: "1"}) {
+		t.Errorf("decode = key %q, value %q, headers %v", record.Key, record.Value, record.Headers)
+	}
+
+	// A blank line of a value object with side objects is an empty record
+	record = &restoreRecord{}
+	if err := decoder.decode([]byte{}, []byte{}, []byte{}, record); err != nil {
+		t.Fatal(err)
+	}
+	if record.Key != "" || record.Value != "" || record.Headers != nil {
+		t.Errorf("decode of blank lines = key %q, value %q, headers %v", record.Key, record.Value, record.Headers)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// legacyDateLayout is the whole-day date format used before RFC3339 windows were supported
+	legacyDateLayout = "02/01/2006"
+
+	timestampLayoutUnixMillis = "unix_ms"
+	timestampLayoutUnix       = "unix"
+)
+
+// fallbackTimestampLayouts are tried when no record timestamp layout is configured
+var fallbackTimestampLayouts = []string{time.RFC3339Nano, "2006-01-02 15:04:05,000", "2006-01-02 15:04:05.000", "2006-01-02 15:04:05"}
+
+// restoreWindow is the time range to restore. The start is inclusive and the end is exclusive.
+type restoreWindow struct {
+	start time.Time
+	end   time.Time
+}
+
+// parseRestoreWindow parses the start and end of the restore.
+// Both accept RFC3339 timestamps, or a 02/01/2006 date that stands for the whole day.
+func parseRestoreWindow(start string, end string) (restoreWindow, error) {
+	var window restoreWindow
+	var err error
+
+	window.start, err = parseRestoreTime(start, false)
+	if err != nil {
+		return window, fmt.Errorf("error parsing start restore date: %v", err)
+	}
+	window.end, err = parseRestoreTime(end, true)
+	if err != nil {
+		return window, fmt.Errorf("error parsing end restore date: %v", err)
+	}
+	if !window.end.After(window.start) {
+		return window, fmt.Errorf("end restore date %v must be after start restore date %v", window.end, window.start)
+	}
+	return window, nil
+}
+
+// parseRestoreTime parses a single edge of the window. A legacy end date covers its entire day.
+func parseRestoreTime(value string, isEnd bool) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if parsed, err := time.Parse(time.RFC3339, value); err == nil {
+		return parsed.UTC(), nil
+	}
+
+	parsed, err := time.Parse(legacyDateLayout, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is neither RFC3339 nor %s", value, legacyDateLayout)
+	}
+	if isEnd {
+		parsed = parsed.AddDate(0, 0, 1)
+	}
+	return parsed, nil
+}
+
+// contains reports whether t is inside the window
+func (w restoreWindow) contains(t time.Time) bool {
+	return !t.Before(w.start) && t.Before(w.end)
+}
+
+// covers reports whether the whole range between periodStart and the exclusive periodEnd is inside the window
+func (w restoreWindow) covers(periodStart time.Time, periodEnd time.Time) bool {
+	return periodEnd.After(periodStart) && !periodStart.Before(w.start) && !periodEnd.After(w.end)
+}
+
+// timestampExtractor reads the timestamp of a record from a field of its JSON value
+type timestampExtractor struct {
+	path   []string
+	layout string
+}
+
+// newTimestampExtractor returns an extractor for a dotted field path, or nil when field is empty
+func newTimestampExtractor(field string, layout string) *timestampExtractor {
+	if field == "" {
+		return nil
+	}
+	return &timestampExtractor{path: strings.Split(field, "."), layout: layout}
+}
+
+// extract returns the timestamp of a record, and false when the record has no readable timestamp
+func (e *timestampExtractor) extract(record []byte) (time.Time, bool) {
+	raw := json.RawMessage(record)
+	for _, field := range e.path {
+		var object map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &object); err != nil {
+			return time.Time{}, false
+		}
+		value, ok := object[field]
+		if !ok {
+			return time.Time{}, false
+		}
+		raw = value
+	}
+	return e.parse(bytes.TrimSpace(raw))
+}
+
+// parse converts a JSON number or string into a time according to the configured layout
+func (e *timestampExtractor) parse(raw []byte) (time.Time, bool) {
+	var text string
+	if len(raw) > 0 && raw[0] == '"' {
+		if err := json.Unmarshal(raw, &text); err != nil {
+			return time.Time{}, false
+		}
+	} else {
+		text = string(raw)
+	}
+
+	switch e.layout {
+	case timestampLayoutUnixMillis, timestampLayoutUnix:
+		number, err := strconv.ParseInt(text, 10, 64)
+		if err != nil {
+			return time.Time{}, false
+		}
+		if e.layout == timestampLayoutUnix {
+			return time.Unix(number, 0).UTC(), true
+		}
+		return time.Unix(0, number*int64(time.Millisecond)).UTC(), true
+	case "":
+		// Numbers are epoch milliseconds, as written by Kafka and Kafka Connect
+		if number, err := strconv.ParseInt(text, 10, 64); err == nil {
+			return time.Unix(0, number*int64(time.Millisecond)).UTC(), true
+		}
+		for _, layout := range fallbackTimestampLayouts {
+			if parsed, err := time.Parse(layout, text); err == nil {
+				return parsed.UTC(), true
+			}
+		}
+		return time.Time{}, false
+	}
+
+	parsed, err := time.Parse(e.layout, text)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return parsed.UTC(), true
+}
+
+// recordInWindow reports whether a record of an edge period should be restored.
+// Records without a timestamp of their own are placed by the timestamp read from their value, and records
+// without any readable timestamp are kept, since they can't be placed outside the window.
+func recordInWindow(record *restoreRecord, window restoreWindow, timestamps *timestampExtractor) bool {
+	timestamp := record.Timestamp
+	if timestamp.IsZero() && timestamps != nil {
+		timestamp, _ = timestamps.extract([]byte(record.Value))
+	}
+	return timestamp.IsZero() || window.contains(timestamp)
+}
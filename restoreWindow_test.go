@@ -0,0 +1,122 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRestoreWindow(t *testing.T) {
+	tests := []struct {
+		start string
+		end   string
+		want  restoreWindow
+		fails bool
+	}{
+		{
+			start: "2020-04-27T10:00:00Z",
+			end:   "2020-04-27T12:30:00Z",
+			want: restoreWindow{
+				start: time.Date(2020, 4, 27, 10, 0, 0, 0, time.UTC),
+				end:   time.Date(2020, 4, 27, 12, 30, 0, 0, time.UTC),
+			},
+		},
+		{
+			start: "2020-04-27T12:00:00+02:00",
+			end:   "2020-04-27T13:00:00+02:00",
+			want: restoreWindow{
+				start: time.Date(2020, 4, 27, 10, 0, 0, 0, time.UTC),
+				end:   time.Date(2020, 4, 27, 11, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			// A legacy end date covers its entire day
+			start: "27/04/2020",
+			end:   "27/04/2020",
+			want: restoreWindow{
+				start: time.Date(2020, 4, 27, 0, 0, 0, 0, time.UTC),
+				end:   time.Date(2020, 4, 28, 0, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			start: "27/04/2020",
+			end:   "2020-04-27T06:00:00Z",
+			want: restoreWindow{
+				start: time.Date(2020, 4, 27, 0, 0, 0, 0, time.UTC),
+				end:   time.Date(2020, 4, 27, 6, 0, 0, 0, time.UTC),
+			},
+		},
+		{start: "", end: "2020-04-27T06:00:00Z", fails: true},
+		{start: "2020-04-27 00:00:00 +0000 UTC", end: "28/04/2020", fails: true},
+		{start: "2020-04-27T12:00:00Z", end: "2020-04-27T12:00:00Z", fails: true},
+		{start: "28/04/2020", end: "27/04/2020", fails: true},
+	}
+
+	for _, test := range tests {
+		window, err := parseRestoreWindow(test.start, test.end)
+		if test.fails {
+			if err == nil {
+				t.Errorf("parseRestoreWindow(%q, %q) = %v, want an error", test.start, test.end, window)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseRestoreWindow(%q, %q): %v", test.start, test.end, err)
+			continue
+		}
+		if !window.start.Equal(test.want.start) || !window.end.Equal(test.want.end) {
+			t.Errorf("parseRestoreWindow(%q, %q) = %v - %v, want %v - %v",
+				test.start, test.end, window.start, window.end, test.want.start, test.want.end)
+		}
+	}
+}
+
+func TestRestoreWindowCovers(t *testing.T) {
+	window := restoreWindow{
+		start: time.Date(2020, 4, 27, 10, 0, 0, 0, time.UTC),
+		end:   time.Date(2020, 4, 27, 12, 0, 0, 0, time.UTC),
+	}
+	hour := func(h int) time.Time { return time.Date(2020, 4, 27, h, 0, 0, 0, time.UTC) }
+
+	tests := []struct {
+		start  time.Time
+		end    time.Time
+		covers bool
+	}{
+		{start: hour(10), end: hour(11), covers: true},
+		{start: hour(11), end: hour(12), covers: true},
+		{start: hour(10), end: hour(12), covers: true},
+		{start: hour(9), end: hour(10), covers: false},
+		{start: hour(9), end: hour(11), covers: false},
+		{start: hour(11), end: hour(13), covers: false},
+		{start: hour(0), end: hour(24), covers: false},
+		{start: hour(11), end: hour(11), covers: false},
+	}
+
+	for _, test := range tests {
+		if covers := window.covers(test.start, test.end); covers != test.covers {
+			t.Errorf("covers(%v, %v) = %v, want %v", test.start, test.end, covers, test.covers)
+		}
+	}
+}
+
+func TestRestoreWindowContains(t *testing.T) {
+	window := restoreWindow{
+		start: time.Date(2020, 4, 27, 10, 0, 0, 0, time.UTC),
+		end:   time.Date(2020, 4, 27, 12, 0, 0, 0, time.UTC),
+	}
+	tests := []struct {
+		t        time.Time
+		contains bool
+	}{
+		{t: window.start, contains: true},
+		{t: window.end.Add(-time.Nanosecond), contains: true},
+		{t: window.end, contains: false},
+		{t: window.start.Add(-time.Nanosecond), contains: false},
+	}
+
+	for _, test := range tests {
+		if contains := window.contains(test.t); contains != test.contains {
+			t.Errorf("contains(%v) = %v, want %v", test.t, contains, test.contains)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"crypto/sha512"
+	"fmt"
+	"strings"
+
+	"github.com/Shopify/sarama"
+	"github.com/xdg/scram"
+)
+
+const (
+	saslMechanismPlain       = sarama.SASLTypePlaintext
+	saslMechanismSCRAMSHA256 = sarama.SASLTypeSCRAMSHA256
+	saslMechanismSCRAMSHA512 = sarama.SASLTypeSCRAMSHA512
+)
+
+// saslOptions configures the SASL authentication of the producer. An empty mechanism disables it.
+type saslOptions struct {
+	mechanism string
+	username  string
+	password  string
+}
+
+// validateSASLMechanism normalizes a configured mechanism, such as scram-sha-512
+func validateSASLMechanism(mechanism string) (string, error) {
+	mechanism = strings.ToUpper(strings.TrimSpace(mechanism))
+	switch mechanism {
+	case "", saslMechanismPlain, saslMechanismSCRAMSHA256, saslMechanismSCRAMSHA512:
+		return mechanism, nil
+	}
+	return "", fmt.Errorf("unknown SASL mechanism %q, expected %s, %s or %s", mechanism, saslMechanismPlain, saslMechanismSCRAMSHA256, saslMechanismSCRAMSHA512)
+}
+
+// configure enables the SASL authentication of a producer configuration
+func (o saslOptions) configure(config *sarama.Config) error {
+	if o.mechanism == "" {
+		return nil
+	}
+	if o.username == "" || o.password == "" {
+		return fmt.Errorf("SASL mechanism %s needs a username and a password", o.mechanism)
+	}
+
+	config.Net.SASL.Enable = true
+	config.Net.SASL.Handshake = true
+	config.Net.SASL.Mechanism = sarama.SASLMechanism(o.mechanism)
+	config.Net.SASL.User = o.username
+	config.Net.SASL.Password = o.password
+	switch o.mechanism {
+	case saslMechanismSCRAMSHA256:
+		config.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient { return &scramClient{hash: scram.SHA256} }
+	case saslMechanismSCRAMSHA512:
+		config.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient { return &scramClient{hash: scram.HashGeneratorFcn(sha512.New)} }
+	}
+	return nil
+}
+
+// scramClient is the SCRAM conversation of a single broker connection
+type scramClient struct {
+	hash         scram.HashGeneratorFcn
+	conversation *scram.ClientConversation
+}
+
+func (c *scramClient) Begin(username, password, authzID string) error {
+	client, err := c.hash.NewClient(username, password, authzID)
+	if err != nil {
+		return err
+	}
+	c.conversation = client.NewConversation()
+	return nil
+}
+
+func (c *scramClient) Step(challenge string) (string, error) {
+	return c.conversation.Step(challenge)
+}
+
+func (c *scramClient) Done() bool {
+	return c.conversation.Done()
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+)
+
+func TestSASLOptionsConfigure(t *testing.T) {
+	tests := []struct {
+		name    string
+		options saslOptions
+		enabled bool
+		scram   bool
+		fails   bool
+	}{
+		{name: "disabled", options: saslOptions{}, enabled: false},
+		{name: "disabled with credentials", options: saslOptions{username: "restore", password: "secret"}, enabled: false},
+		{name: "plain", options: saslOptions{mechanism: saslMechanismPlain, username: "restore", password: "secret"}, enabled: true},
+		{name: "scram-sha-256", options: saslOptions{mechanism: saslMechanismSCRAMSHA256, username: "restore", password: "secret"}, enabled: true, scram: true},
+		{name: "scram-sha-512", options: saslOptions{mechanism: saslMechanismSCRAMSHA512, username: "restore", password: "secret"}, enabled: true, scram: true},
+		{name: "no username", options: saslOptions{mechanism: saslMechanismPlain, password: "secret"}, fails: true},
+		{name: "no password", options: saslOptions{mechanism: saslMechanismSCRAMSHA512, username: "restore"}, fails: true},
+	}
+
+	for _, test := range tests {
+		config := sarama.NewConfig()
+		err := test.options.configure(config)
+		if test.fails {
+			if err == nil {
+				t.Errorf("%s: configure succeeded, want an error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: configure: %v", test.name, err)
+			continue
+		}
+
+		sasl := config.Net.SASL
+		if sasl.Enable != test.enabled {
+			t.Errorf("%s: SASL enabled is %v, want %v", test.name, sasl.Enable, test.enabled)
+		}
+		if !test.enabled {
+			continue
+		}
+		if string(sasl.Mechanism) != test.options.mechanism || sasl.User != test.options.username || sasl.Password != test.options.password {
+			t.Errorf("%s: SASL is %s %s, want %s %s", test.name, sasl.Mechanism, sasl.User, test.options.mechanism, test.options.username)
+		}
+		if (sasl.SCRAMClientGeneratorFunc != nil) != test.scram {
+			t.Errorf("%s: SCRAM client is set: %v, want %v", test.name, sasl.SCRAMClientGeneratorFunc != nil, test.scram)
+		}
+		if test.scram {
+			client := sasl.SCRAMClientGeneratorFunc()
+			if err := client.Begin(sasl.User, sasl.Password, ""); err != nil {
+				t.Errorf("%s: SCRAM Begin: %v", test.name, err)
+				continue
+			}
+			first, err := client.Step("")
+			if err != nil || first == "" || client.Done() {
+				t.Errorf("%s: first SCRAM message is %q, %v", test.name, first, err)
+			}
+		}
+		if err := config.Validate(); err != nil {
+			t.Errorf("%s: invalid producer configuration: %v", test.name, err)
+		}
+	}
+}
+
+func TestValidateSASLMechanism(t *testing.T) {
+	tests := []struct {
+		mechanism string
+		want      string
+		fails     bool
+	}{
+		{mechanism: "", want: ""},
+		{mechanism: "plain", want: saslMechanismPlain},
+		{mechanism: " scram-sha-256 ", want: saslMechanismSCRAMSHA256},
+		{mechanism: "SCRAM-SHA-512", want: saslMechanismSCRAMSHA512},
+		{mechanism: "GSSAPI", fails: true},
+	}
+
+	for _, test := range tests {
+		mechanism, err := validateSASLMechanism(test.mechanism)
+		if test.fails {
+			if err == nil {
+				t.Errorf("validateSASLMechanism(%q) = %q, want an error", test.mechanism, mechanism)
+			}
+			continue
+		}
+		if err != nil || mechanism != test.want {
+			t.Errorf("validateSASLMechanism(%q) = %q, %v, want %q", test.mechanism, mechanism, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	schemaRegistryContentType = "application/vnd.schemaregistry.v1+json"
+	schemaRegistryTimeout     = 30 * time.Second
+
+	// Subjects follow the TopicNameStrategy of the Confluent serializers
+	subjectValueSuffix = "-value"
+	subjectKeySuffix   = "-key"
+)
+
+// schemaRegistry registers the schemas of the restored records, and returns their ids
+type schemaRegistry interface {
+	register(subject string, schema string) (int, error)
+}
+
+// httpSchemaRegistry is a client of the REST API of a Confluent compatible Schema Registry.
+// The ids are cached, so every schema is registered once per subject.
+type httpSchemaRegistry struct {
+	url      string
+	username string
+	password string
+	client   *http.Client
+
+	mutex sync.Mutex
+	ids   map[string]int
+}
+
+// newSchemaRegistry returns a client of the registry at registryURL, or nil when registryURL is empty.
+// The username and password are sent with basic authentication when they're set.
+func newSchemaRegistry(registryURL string, username string, password string) (schemaRegistry, error) {
+	if registryURL == "" {
+		return nil, nil
+	}
+	if _, err := url.ParseRequestURI(registryURL); err != nil {
+		return nil, fmt.Errorf("invalid schema registry url %q: %v", registryURL, err)
+	}
+	return &httpSchemaRegistry{
+		url:      strings.TrimSuffix(registryURL, "/"),
+		username: username,
+		password: password,
+		client:   &http.Client{Timeout: schemaRegistryTimeout},
+		ids:      make(map[string]int),
+	}, nil
+}
+
+// register registers a schema under a subject. A schema the subject already has keeps its id.
+func (r *httpSchemaRegistry) register(subject string, schema string) (int, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	cacheKey := subject + "\x00" + schema
+	if id, ok := r.ids[cacheKey]; ok {
+		return id, nil
+	}
+
+	body, err := json.Marshal(map[string]string{"schema": schema})
+	if err != nil {
+		return 0, err
+	}
+	request, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/subjects/%s/versions", r.url, url.PathEscape(subject)), bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	request.Header.Set("Content-Type", schemaRegistryContentType)
+	request.Header.Set("Accept", schemaRegistryContentType)
+	if r.username != "" {
+		request.SetBasicAuth(r.username, r.password)
+	}
+
+	response, err := r.client.Do(request)
+	if err != nil {
+		return 0, fmt.Errorf("error registering schema of subject %s: %v", subject, err)
+	}
+	defer response.Body.Close()
+	responseBody, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return 0, fmt.Errorf("error registering schema of subject %s: %v", subject, err)
+	}
+	if response.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("error registering schema of subject %s: %s %s", subject, response.Status, strings.TrimSpace(string(responseBody)))
+	}
+
+	var registered struct {
+		ID int `json:"id"`
+	}
+	if err := json.Unmarshal(responseBody, &registered); err != nil {
+		return 0, fmt.Errorf("invalid schema registry response for subject %s: %v", subject, err)
+	}
+	r.ids[cacheKey] = registered.ID
+	logger.info(componentMain, fmt.Sprintf("Registered schema %d under subject %s", registered.ID, subject))
+	return registered.ID, nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// fakeSchemaRegistry serves the register endpoint of a schema registry, and gives every new schema of a
+// subject the next id
+type fakeSchemaRegistry struct {
+	requests int
+	ids      map[string]int
+	username string
+	password string
+}
+
+func (f *fakeSchemaRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.requests++
+	if username, password, ok := r.BasicAuth(); f.username != "" && (!ok || username != f.username || password != f.password) {
+		http.Error(w, `{"error_code":401,"message":"Unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodPost || r.Header.Get("Content-Type") != schemaRegistryContentType {
+		http.Error(w, `{"error_code":405}`, http.StatusMethodNotAllowed)
+		return
+	}
+	var body struct {
+		Schema string `json:"schema"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Schema == "" {
+		http.Error(w, `{"error_code":42201,"message":"Invalid schema"}`, http.StatusUnprocessableEntity)
+		return
+	}
+
+	key := r.URL.EscapedPath() + " " + body.Schema
+	id, ok := f.ids[key]
+	if !ok {
+		id = len(f.ids) + 1
+		f.ids[key] = id
+	}
+	json.NewEncoder(w).Encode(map[string]int{"id": id})
+}
+
+func TestHTTPSchemaRegistryRegister(t *testing.T) {
+	fake := &fakeSchemaRegistry{ids: make(map[string]int), username: "restore", password: "registry-password"}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	registry, err := newSchemaRegistry(server.URL+"/", fake.username, fake.password)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		subject  string
+		schema   string
+		id       int
+		requests int
+		fails    bool
+	}{
+		{subject: "orders" + subjectValueSuffix, schema: `"string"`, id: 1, requests: 1},
+		// A registered schema is served from the cache
+		{subject: "orders" + subjectValueSuffix, schema: `"string"`, id: 1, requests: 1},
+		{subject: "orders" + subjectKeySuffix, schema: `"string"`, id: 2, requests: 2},
+		{subject: "orders" + subjectValueSuffix, schema: `"long"`, id: 3, requests: 3},
+		{subject: "my/topic" + subjectValueSuffix, schema: `"int"`, id: 4, requests: 4},
+		{subject: "orders" + subjectValueSuffix, schema: "", fails: true, requests: 5},
+	}
+
+	for _, test := range tests {
+		id, err := registry.register(test.subject, test.schema)
+		if test.fails {
+			if err == nil {
+				t.Errorf("register(%q, %q) = %d, want an error", test.subject, test.schema, id)
+			}
+		} else if err != nil || id != test.id {
+			t.Errorf("register(%q, %q) = %d, %v, want %d", test.subject, test.schema, id, err, test.id)
+		}
+		if fake.requests != test.requests {
+			t.Errorf("register(%q, %q) made %d requests in total, want %d", test.subject, test.schema, fake.requests, test.requests)
+		}
+	}
+	if _, ok := fake.ids["/subjects/my%2Ftopic-value/versions "+`"int"`]; !ok {
+		t.Errorf("the subject wasn't escaped in the path: %v", fake.ids)
+	}
+}
+
+func TestHTTPSchemaRegistryUnauthorized(t *testing.T) {
+	fake := &fakeSchemaRegistry{ids: make(map[string]int), username: "restore", password: "registry-password"}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	registry, err := newSchemaRegistry(server.URL, fake.username, "wrong")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, err := registry.register("orders-value", `"string"`); err == nil {
+		t.Errorf("register with a wrong password = %d, want an error", id)
+	}
+}
+
+func TestNewSchemaRegistry(t *testing.T) {
+	registry, err := newSchemaRegistry("", "", "")
+	if registry != nil || err != nil {
+		t.Errorf("newSchemaRegistry without a url = %v, %v, want none", registry, err)
+	}
+	if _, err := newSchemaRegistry("not a url", "", ""); err == nil {
+		t.Errorf("newSchemaRegistry with an invalid url succeeded")
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	redactedText = "[REDACTED]"
+
+	// minSecretLength keeps short values, which would mask unrelated text, out of the redaction
+	minSecretLength = 4
+)
+
+var (
+	// pemBlockPattern matches the PEM blocks of keys and certificates, including the escaped ones of JSON
+	pemBlockPattern = regexp.MustCompile(`-----BEGIN ([A-Z0-9 ]+)-----(?s:.*?)-----END [A-Z0-9 ]+-----`)
+	// urlCredentialsPattern matches the password of the credentials inside a URL
+	urlCredentialsPattern = regexp.MustCompile(`(://[^/:@\s]+:)[^/@\s]+@`)
+	// secretKeyPattern matches the configuration keys that hold secrets or private keys. Keys ending in _file hold
+	// the path of a secret rather than the secret, and certificates are public.
+	secretKeyPattern = regexp.MustCompile(`(secret|password|token|_key$)`)
+)
+
+// secretRedactor masks the known secret values, the PEM blocks and the URL passwords of a text.
+// It's used by the logger and the final error report, so no secret reaches stdout or the log file.
+type secretRedactor struct {
+	mutex  sync.RWMutex
+	values []string
+}
+
+var secrets = &secretRedactor{}
+
+// add registers a secret value. Values shorter than minSecretLength are ignored.
+func (r *secretRedactor) add(value string) {
+	value = strings.TrimSpace(value)
+	if len(value) < minSecretLength {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, known := range r.values {
+		if known == value {
+			return
+		}
+	}
+	r.values = append(r.values, value)
+	// The longest values are replaced first, so a secret that contains another is masked whole
+	sort.Slice(r.values, func(i, j int) bool { return len(r.values[i]) > len(r.values[j]) })
+}
+
+// redact returns text with its secrets masked
+func (r *secretRedactor) redact(text string) string {
+	text = pemBlockPattern.ReplaceAllString(text, "-----BEGIN $1----- "+redactedText+" -----END $1-----")
+	text = urlCredentialsPattern.ReplaceAllString(text, "${1}"+redactedText+"@")
+
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	for _, value := range r.values {
+		text = strings.Replace(text, value, redactedText, -1)
+	}
+	return text
+}
+
+// redactEvent masks the secrets of a log event. Only text and errors can carry them.
+func (r *secretRedactor) redactEvent(event interface{}) interface{} {
+	switch value := event.(type) {
+	case string:
+		return r.redact(value)
+	case error:
+		return r.redact(value.Error())
+	case fmt.Stringer:
+		return r.redact(value.String())
+	}
+	return event
+}
+
+// isSecretConfigKey reports whether a configuration key holds a secret
+func isSecretConfigKey(key string) bool {
+	return !strings.HasSuffix(key, "_file") && secretKeyPattern.MatchString(key)
+}
+
+// registerConfigSecrets registers the values of the secret configuration keys
+func registerConfigSecrets() {
+	for _, key := range configKeys() {
+		if isSecretConfigKey(key) {
+			secrets.add(viper.GetString(key))
+		}
+	}
+}
+
+// configKeys returns the configuration keys that have a value, from the defaults or from the environment
+func configKeys() []string {
+	keys := make(map[string]bool)
+	for _, key := range viper.AllKeys() {
+		keys[key] = true
+	}
+	prefix := strings.ToUpper(configPrefix) + "_"
+	for _, variable := range os.Environ() {
+		name := strings.SplitN(variable, "=", 2)[0]
+		if strings.HasPrefix(name, prefix) {
+			keys[strings.ToLower(strings.TrimPrefix(name, prefix))] = true
+		}
+	}
+
+	sorted := make([]string, 0, len(keys))
+	for key := range keys {
+		sorted = append(sorted, key)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+// writeEffectiveConfig prints the configuration the restore runs with, with the secrets redacted
+func writeEffectiveConfig(output io.Writer) {
+	for _, key := range configKeys() {
+		value := fmt.Sprint(viper.Get(key))
+		if isSecretConfigKey(key) && value != "" {
+			value = redactedText
+		}
+		fmt.Fprintf(output, "%s=%s\n", key, secrets.redact(value))
+	}
+}
+
+// reportPanic logs a panic of main with its secrets redacted, and exits like an unrecovered panic would.
+// It must be deferred, since the runtime prints the panic value as is.
+func reportPanic() {
+	recovered := recover()
+	if recovered == nil {
+		return
+	}
+	message := secrets.redact(fmt.Sprint(recovered))
+	logger.fatal(componentMain, message)
+	fmt.Fprintf(os.Stderr, "panic: %s\n\n%s", message, secrets.redact(string(debug.Stack())))
+	os.Exit(2)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// defaultShutdownGracePeriod leaves room for the final checkpoint and summary inside the 30 seconds Kubernetes
+// waits between SIGTERM and SIGKILL
+const defaultShutdownGracePeriod = 20 * time.Second
+
+// cancelOnSignal returns a context that is cancelled on SIGTERM or an interrupt, so the restore stops and
+// persists its progress. A second signal exits at once.
+func cancelOnSignal(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+
+	go func() {
+		select {
+		case received := <-signals:
+			logger.warning(componentMain, fmt.Sprintf("Received %v, stopping the restore", received))
+			cancel()
+		case <-ctx.Done():
+			signal.Stop(signals)
+			return
+		}
+
+		received := <-signals
+		logger.error(componentMain, fmt.Sprintf("Received %v again, exiting without waiting for the producer", received))
+		os.Exit(1)
+	}()
+	return ctx, cancel
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+const (
+	topicVarSourceTopic = "{source_topic}"
+	topicVarProject     = "{project}"
+	topicVarSite        = "{site}"
+	topicVarDepType     = "{dep_type}"
+	topicVarDate        = "{date}"
+
+	defaultTargetTopicTemplate = "{source_topic}-restore"
+
+	// Entries of the mapping table look like source=target, and are separated by commas
+	topicMappingDelimiter     = ","
+	topicMappingPairDelimiter = "="
+
+	sourceTopicsDelimiter = ","
+
+	maxTopicNameLength = 249
+)
+
+var validTopicName = regexp.MustCompile(`^[a-zA-Z0-9._-]+$`)
+
+// topicRestore is the restore of one source topic into its target topic
+type topicRestore struct {
+	Source  string
+	Target  string
+	Objects []restoreObject
+}
+
+// topicMapper decides which topic the records of a source topic are restored into.
+// An explicit mapping wins over the template.
+type topicMapper struct {
+	mapping  map[string]string
+	replacer *strings.Replacer
+	template string
+}
+
+// newTopicMapper returns a mapper for a target topic template and a source=target mapping table.
+// The template may use {source_topic}, {project}, {site}, {dep_type} and {date}, the day the restore window starts.
+func newTopicMapper(template string, mapping string, project string, site string, depType string, date time.Time) (*topicMapper, error) {
+	if template == "" {
+		template = defaultTargetTopicTemplate
+	}
+	for _, variable := range layoutVarPattern.FindAllString(template, -1) {
+		switch variable {
+		case topicVarSourceTopic, topicVarProject, topicVarSite, topicVarDepType, topicVarDate:
+		default:
+			return nil, fmt.Errorf("unknown variable %s in target topic template %q", variable, template)
+		}
+	}
+
+	mapper := &topicMapper{
+		mapping:  make(map[string]string),
+		template: template,
+		replacer: strings.NewReplacer(
+			topicVarProject, project,
+			topicVarSite, site,
+			topicVarDepType, depType,
+			topicVarDate, date.UTC().Format("2006-01-02"),
+		),
+	}
+
+	for _, entry := range strings.Split(mapping, topicMappingDelimiter) {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		pair := strings.SplitN(entry, topicMappingPairDelimiter, 2)
+		if len(pair) != 2 || strings.TrimSpace(pair[0]) == "" || strings.TrimSpace(pair[1]) == "" {
+			return nil, fmt.Errorf("invalid topic mapping entry %q, expected source%starget", entry, topicMappingPairDelimiter)
+		}
+		target := strings.TrimSpace(pair[1])
+		if err := validateTopicName(target); err != nil {
+			return nil, err
+		}
+		mapper.mapping[strings.TrimSpace(pair[0])] = target
+	}
+	return mapper, nil
+}
+
+// target returns the topic the records of a source topic are restored into
+func (m *topicMapper) target(source string) (string, error) {
+	if target, ok := m.mapping[source]; ok {
+		return target, nil
+	}
+	target := strings.Replace(m.replacer.Replace(m.template), topicVarSourceTopic, source, -1)
+	return target, validateTopicName(target)
+}
+
+// validateTopicName checks a topic name against the rules of Kafka
+func validateTopicName(topic string) error {
+	if topic == "." || topic == ".." || len(topic) > maxTopicNameLength || !validTopicName.MatchString(topic) {
+		return fmt.Errorf("invalid target topic name %q", topic)
+	}
+	return nil
+}
+
+// resolveSourceTopics returns the sorted source topics of the restore: the listed topics, together with the
+// topics in the backup bucket that match the glob pattern
+func resolveSourceTopics(s3Client *s3.S3, bucket string, layout *keyLayout, topicsList string, pattern string) ([]string, error) {
+	selected := make(map[string]bool)
+	for _, topic := range strings.Split(topicsList, sourceTopicsDelimiter) {
+		if topic = strings.TrimSpace(topic); topic != "" {
+			selected[topic] = true
+		}
+	}
+
+	if pattern != "" {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid source topic pattern %q: %v", pattern, err)
+		}
+		prefix, err := layout.topicsPrefix()
+		if err != nil {
+			return nil, err
+		}
+		backedUp, err := listTopicNames(s3Client, bucket, prefix)
+		if err != nil {
+			return nil, err
+		}
+		for _, topic := range backedUp {
+			if matched, _ := path.Match(pattern, topic); matched {
+				selected[topic] = true
+			}
+		}
+	}
+
+	topics := make([]string, 0, len(selected))
+	for topic := range selected {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	if len(topics) == 0 {
+		return nil, fmt.Errorf("no source topics to restore in bucket %s", bucket)
+	}
+	return topics, nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTopicMapper(t *testing.T) {
+	date := time.Date(2020, 4, 27, 22, 30, 0, 0, time.FixedZone("CEST", 2*60*60))
+	tests := []struct {
+		name     string
+		template string
+		mapping  string
+		source   string
+		target   string
+		fails    bool
+	}{
+		{name: "default template", source: "orders", target: "orders-restore"},
+		{name: "every variable", template: "{project}.{site}.{dep_type}.{source_topic}.{date}", source: "orders", target: "shop.eu1.prod.orders.2020-04-27"},
+		{name: "repeated variable", template: "{source_topic}-{source_topic}", source: "orders", target: "orders-orders"},
+		{name: "mapped", template: "{source_topic}-copy", mapping: "orders=orders-replay, payments=payments-old", source: "orders", target: "orders-replay"},
+		{name: "mapped with spaces", mapping: " , payments = payments-old ,", source: "payments", target: "payments-old"},
+		{name: "not mapped", template: "{source_topic}-copy", mapping: "payments=payments-old", source: "orders", target: "orders-copy"},
+		{name: "invalid target from the template", template: "{source_topic}/copy", source: "orders", fails: true},
+		{name: "misspelled variable", template: "{source-topic}-restore", source: "orders", fails: true},
+	}
+
+	for _, test := range tests {
+		mapper, err := newTopicMapper(test.template, test.mapping, "shop", "eu1", "prod", date)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		target, err := mapper.target(test.source)
+		if test.fails {
+			if err == nil {
+				t.Errorf("%s: target(%q) = %q, want an error", test.name, test.source, target)
+			}
+			continue
+		}
+		if err != nil || target != test.target {
+			t.Errorf("%s: target(%q) = %q, %v, want %q", test.name, test.source, target, err, test.target)
+		}
+	}
+}
+
+func TestNewTopicMapperErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		mapping  string
+	}{
+		{name: "unknown variable", template: "{source_topic}-{year}"},
+		{name: "entry without a target", mapping: "orders"},
+		{name: "empty source", mapping: "=orders-restore"},
+		{name: "empty target", mapping: "orders=, payments=payments-old"},
+		{name: "invalid target", mapping: "orders=orders restore"},
+		{name: "target too long", mapping: "orders=" + string(make([]byte, maxTopicNameLength+1))},
+	}
+
+	for _, test := range tests {
+		if _, err := newTopicMapper(test.template, test.mapping, "shop", "eu1", "prod", time.Now()); err == nil {
+			t.Errorf("%s: newTopicMapper(%q, %q) succeeded", test.name, test.template, test.mapping)
+		}
+	}
+}
+
+func TestValidateTopicName(t *testing.T) {
+	tests := []struct {
+		topic string
+		valid bool
+	}{
+		{topic: "orders", valid: true},
+		{topic: "orders.v1_replay-2", valid: true},
+		{topic: "", valid: false},
+		{topic: ".", valid: false},
+		{topic: "..", valid: false},
+		{topic: "orders/replay", valid: false},
+	}
+
+	for _, test := range tests {
+		if err := validateTopicName(test.topic); (err == nil) != test.valid {
+			t.Errorf("validateTopicName(%q) = %v, want valid: %v", test.topic, err, test.valid)
+		}
+	}
+}
+
+func TestResolveSourceTopics(t *testing.T) {
+	objects := make(map[string]*fakeS3Object)
+	for _, key := range []string{
+		"topics/orders/year=2020/month=04/day=27/orders+0+0000000000.json",
+		"topics/orders/year=2020/month=04/day=28/orders+0+0000000100.json",
+		"topics/orders.v1/year=2020/month=04/day=27/orders.v1+0+0000000000.json",
+		"topics/payments/year=2020/month=04/day=27/payments+0+0000000000.json",
+		"topics/users/year=2020/month=04/day=27/users+0+0000000000.json",
+		"other/orders-old/year=2020/month=04/day=27/orders-old+0+0000000000.json",
+	} {
+		objects[key] = &fakeS3Object{body: "{}\n"}
+	}
+	fake := newFakeS3(objects)
+	// The topics are listed over several pages
+	fake.pageSize = 2
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	s3Client := newTestS3Client(server)
+
+	layout, err := newKeyLayout(defaultKeyLayout, defaultTopicsDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		list    string
+		pattern string
+		topics  []string
+		fails   bool
+	}{
+		{name: "list", list: "payments, orders,", topics: []string{"orders", "payments"}},
+		{name: "list without the bucket", list: "archived", topics: []string{"archived"}},
+		{name: "glob", pattern: "orders*", topics: []string{"orders", "orders.v1"}},
+		{name: "list and glob", list: "archived", pattern: "*s", topics: []string{"archived", "orders", "payments", "users"}},
+		{name: "duplicates", list: "orders,payments,orders", pattern: "orders", topics: []string{"orders", "payments"}},
+		{name: "every topic", pattern: "*", topics: []string{"orders", "orders.v1", "payments", "users"}},
+		{name: "nothing matched", pattern: "invoices*", fails: true},
+		{name: "nothing", list: " , ", fails: true},
+		{name: "invalid glob", list: "orders", pattern: "[", fails: true},
+	}
+
+	for _, test := range tests {
+		topics, err := resolveSourceTopics(s3Client, testBucket, layout, test.list, test.pattern)
+		if test.fails {
+			if err == nil {
+				t.Errorf("%s: resolveSourceTopics = %v, want an error", test.name, topics)
+			}
+			continue
+		}
+		if err != nil || strings.Join(topics, " ") != strings.Join(test.topics, " ") {
+			t.Errorf("%s: resolveSourceTopics = %v, %v, want %v", test.name, topics, err, test.topics)
+		}
+	}
+
+	// A layout without a fixed topics directory can't be globbed
+	layout, err = newKeyLayout("{year}/{topic}/{month}", defaultTopicsDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if topics, err := resolveSourceTopics(s3Client, testBucket, layout, "", "*"); err == nil {
+		t.Errorf("resolveSourceTopics with a dated topic directory = %v, want an error", topics)
+	}
+}